@@ -2,27 +2,34 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
+	"os/exec"
 	"path"
 	"runtime"
 	"runtime/debug"
 
 	lsp_logger "github.com/corymhall/pulumilsp/logger"
 	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/corymhall/pulumilsp/pulumicommand"
 	"github.com/corymhall/pulumilsp/rpc"
 	"github.com/corymhall/pulumilsp/server"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == pulumicommand.AnalyzerShimCommand {
+		runAnalyzerShim(os.Args[2:])
+		return
+	}
 	defer panicHandler()
 	ctx := context.Background()
 	logger := getLogger()
 	stream := rpc.NewHeaderStream(os.Stdin, os.Stdout)
-	conn := rpc.NewConn(stream)
+	conn := rpc.NewConn(stream, lsp.ServerConnOption())
 	client := lsp.ClientDispatcher(conn)
 	srv := server.New(client)
 	defer func() {
@@ -36,6 +43,19 @@ func main() {
 	<-conn.Done()
 }
 
+// runAnalyzerShim runs a policy analyzer for a preview, exiting with the exit
+// code of the analyzer.
+func runAnalyzerShim(args []string) {
+	if err := pulumicommand.RunAnalyzerShim(args); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
 func panicHandler() {
 	if panicPayload := recover(); panicPayload != nil {
 		stack := string(debug.Stack())
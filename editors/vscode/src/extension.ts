@@ -23,15 +23,42 @@ export async function activate(
     command: 'pulumilsp',
   };
 
-  const logLevel = vscode.workspace
-    .getConfiguration('pulumilsp')
-    .get<string | undefined>('logLevel');
+  const config = vscode.workspace.getConfiguration('pulumilsp');
+  const logLevel = config.get<string | undefined>('logLevel');
+  const trigger = config.get<string | undefined>('trigger');
+  const previewDelay = config.get<string | undefined>('previewDelay');
+  const stack = config.get<string | undefined>('stack');
+  const policyPacks = config.get<string[] | undefined>('policyPacks');
+  const policyPackConfigs = config.get<Record<string, string> | undefined>(
+    'policyPackConfigs',
+  );
+  const enabledPolicies = config.get<string[] | undefined>('enabledPolicies');
+  const aiProvider = config.get<string | undefined>('aiProvider');
 
   const clientOptions: LanguageClientOptions = {
-    documentSelector: [{ scheme: 'file', language: 'typescript' }],
+    documentSelector: [
+      { scheme: 'file', language: 'typescript' },
+      { scheme: 'file', language: 'typescriptreact' },
+      { scheme: 'file', language: 'javascript' },
+      { scheme: 'file', language: 'javascriptreact' },
+      { scheme: 'file', language: 'python' },
+      { scheme: 'file', language: 'go' },
+      { scheme: 'file', language: 'csharp' },
+      { scheme: 'file', language: 'yaml' },
+    ],
     progressOnInitialization: true,
+    synchronize: {
+      configurationSection: 'pulumilsp',
+    },
     initializationOptions: {
       logLevel: logLevel || 'info',
+      trigger: trigger || 'onSave',
+      previewDelay: previewDelay || '1s',
+      stack: stack || undefined,
+      policyPacks: policyPacks ?? [],
+      policyPackConfigs: policyPackConfigs ?? {},
+      enabledPolicies: enabledPolicies ?? [],
+      aiProvider: aiProvider || undefined,
     },
   };
 
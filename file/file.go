@@ -11,6 +11,8 @@ type Handle interface {
 	URI() lsp.DocumentURI
 	Version() int32
 	Content() ([]byte, error)
+	// Hash returns the hash of the file content.
+	Hash() Hash
 }
 
 type Source interface {
@@ -2,6 +2,8 @@ package file
 
 import (
 	"fmt"
+	"path/filepath"
+	"slices"
 
 	"github.com/corymhall/pulumilsp/lsp"
 )
@@ -16,12 +18,42 @@ const (
 
 	// TypeScript is a TypeScript source file.
 	TypeScript
+
+	// TSX is a TypeScript source file containing JSX.
+	TSX
+
+	// JavaScript is a JavaScript (or JSX) source file.
+	JavaScript
+
+	// Python is a Python source file.
+	Python
+
+	// Go is a Go source file.
+	Go
+
+	// CSharp is a C# source file.
+	CSharp
+
+	// YAML is a Pulumi YAML program file.
+	YAML
 )
 
 func (k Kind) String() string {
 	switch k {
 	case TypeScript:
 		return "typescript"
+	case TSX:
+		return "typescriptreact"
+	case JavaScript:
+		return "javascript"
+	case Python:
+		return "python"
+	case Go:
+		return "go"
+	case CSharp:
+		return "csharp"
+	case YAML:
+		return "yaml"
 	default:
 		return fmt.Sprintf("internal error: unknown file kind %d", k)
 	}
@@ -34,6 +66,56 @@ func KindForLang(langID lsp.LanguageKind) Kind {
 	switch langID {
 	case "typescript":
 		return TypeScript
+	case "typescriptreact":
+		return TSX
+	case "javascript", "javascriptreact":
+		return JavaScript
+	case "python":
+		return Python
+	case "go":
+		return Go
+	case "csharp":
+		return CSharp
+	case "yaml":
+		return YAML
+	default:
+		return UnknownKind
+	}
+}
+
+// ProjectFiles are the names of the file that defines a Pulumi project.
+var ProjectFiles = []string{"Pulumi.yaml", "Pulumi.yml"}
+
+// yamlPrograms are the names of the files a Pulumi YAML program is read
+// from: the project file, or Main.yaml in the directory the main option of
+// the project points to. Other YAML files, e.g. stack configs, aren't
+// programs.
+var yamlPrograms = append([]string{"Main.yaml"}, ProjectFiles...)
+
+// KindForURI returns the file [Kind] associated with the extension of the
+// given URI, or UnknownKind if the extension is not recognized.
+//
+// This is used for URIs that are not opened by the client (e.g. the source
+// positions reported by the Pulumi engine), which have no LanguageID.
+func KindForURI(uri lsp.DocumentURI) Kind {
+	switch filepath.Ext(string(uri)) {
+	case ".ts", ".mts", ".cts":
+		return TypeScript
+	case ".tsx":
+		return TSX
+	case ".js", ".mjs", ".cjs", ".jsx":
+		return JavaScript
+	case ".py":
+		return Python
+	case ".go":
+		return Go
+	case ".cs":
+		return CSharp
+	case ".yaml", ".yml":
+		if slices.Contains(yamlPrograms, filepath.Base(string(uri))) {
+			return YAML
+		}
+		return UnknownKind
 	default:
 		return UnknownKind
 	}
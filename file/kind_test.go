@@ -0,0 +1,22 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKindForURI(t *testing.T) {
+	for uri, kind := range map[lsp.DocumentURI]Kind{
+		"file:///app/index.ts":             TypeScript,
+		"file:///app/Pulumi.yaml":          YAML,
+		"file:///app/Pulumi.yml":           YAML,
+		"file:///app/program/Main.yaml":    YAML,
+		"file:///app/Pulumi.dev.yaml":      UnknownKind,
+		"file:///app/.github/ci.yml":       UnknownKind,
+		"file:///app/config/settings.yaml": UnknownKind,
+	} {
+		require.Equal(t, kind, KindForURI(uri), uri)
+	}
+}
@@ -10,6 +10,8 @@ type Client interface {
 	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#progress
 	ProgressBegin(context.Context, *WorkDoneProgressBeginParams) error
 	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#progress
+	ProgressReport(context.Context, *WorkDoneProgressReportParams) error
+	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#progress
 	ProgressEnd(context.Context, *WorkDoneProgressEndParams) error
 	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#window_showMessage
 	ShowMessage(context.Context, *ShowMessageParams) error
@@ -33,6 +35,10 @@ func (s *clientDispatcher) ProgressBegin(ctx context.Context, params *WorkDonePr
 	return s.sender.Notify(ctx, "$/progress", params)
 }
 
+func (s *clientDispatcher) ProgressReport(ctx context.Context, params *WorkDoneProgressReportParams) error {
+	return s.sender.Notify(ctx, "$/progress", params)
+}
+
 func (s *clientDispatcher) ProgressEnd(ctx context.Context, params *WorkDoneProgressEndParams) error {
 	return s.sender.Notify(ctx, "$/progress", params)
 }
@@ -6,11 +6,17 @@ type InitializeRequestParams struct {
 	WorkDoneProgressCreateParams
 	ClientInfo            *ClientInfo        `json:"clientInfo"`
 	RootURI               DocumentURI        `json:"rootUri"`
+	WorkspaceFolders      []WorkspaceFolder  `json:"workspaceFolders,omitempty"`
 	Capabilities          ClientCapabilities `json:"capabilities"`
 	InitializationOptions *json.RawMessage   `json:"initializationOptions,omitempty"`
 	// ... there's tons more that goes here
 }
 
+type WorkspaceFolder struct {
+	URI  DocumentURI `json:"uri"`
+	Name string      `json:"name"`
+}
+
 type ClientCapabilities struct {
 	Window    ClientWindowCapabilities    `json:"window"`
 	Workspace ClientWorkspaceCapabilities `json:"workspace"`
@@ -50,9 +56,36 @@ type CodeActionProviderOptions struct {
 	ResolveProvider bool             `json:"resolveProvider"`
 }
 
+// TextDocumentSyncKind defines how the client syncs document changes to the
+// server.
+type TextDocumentSyncKind int
+
+const (
+	// TextDocumentSyncNone means documents are not synced at all.
+	TextDocumentSyncNone TextDocumentSyncKind = 0
+	// TextDocumentSyncFull means the full content of the document is sent on
+	// every change.
+	TextDocumentSyncFull TextDocumentSyncKind = 1
+	// TextDocumentSyncIncremental means only the changed ranges of the
+	// document are sent after the document is opened.
+	TextDocumentSyncIncremental TextDocumentSyncKind = 2
+)
+
+type WorkspaceFoldersServerCapabilities struct {
+	Supported           bool `json:"supported"`
+	ChangeNotifications bool `json:"changeNotifications"`
+}
+
+type WorkspaceServerCapabilities struct {
+	WorkspaceFolders *WorkspaceFoldersServerCapabilities `json:"workspaceFolders,omitempty"`
+}
+
 type ServerCapabilities struct {
-	TextDocumentSync   int                       `json:"textDocumentSync"`
-	CodeActionProvider CodeActionProviderOptions `json:"codeActionProvider"`
+	TextDocumentSync   TextDocumentSyncKind         `json:"textDocumentSync"`
+	CodeActionProvider CodeActionProviderOptions    `json:"codeActionProvider"`
+	Workspace          *WorkspaceServerCapabilities `json:"workspace,omitempty"`
+	// ExecuteCommandProvider lists the commands the server can execute.
+	ExecuteCommandProvider *ExecuteCommandOptions `json:"executeCommandProvider,omitempty"`
 	// Not enabled because it sends requests to the server a lot
 	DiagnosticProvider DiagnosticOptions `json:"diagnosticProvider"`
 }
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 
 	"github.com/corymhall/pulumilsp/rpc"
 	"github.com/corymhall/pulumilsp/xcontext"
@@ -24,9 +23,23 @@ func UnmarshalJSON(msg json.RawMessage, v any) error {
 
 var (
 	// RequestCancelledError should be used when a request is cancelled early.
-	RequestCancelledError = errors.New("JSON RPC cancelled")
+	RequestCancelledError = rpc.ErrRequestCancelled
 )
 
+// concurrentMethods are the calls the server handles concurrently, so that
+// they can be cancelled while they are handled. They only read the state of
+// the server.
+var concurrentMethods = []string{
+	"textDocument/codeAction",
+	"codeAction/resolve",
+}
+
+// ServerConnOption returns the option of a connection to a client that
+// handles the calls of concurrentMethods concurrently.
+func ServerConnOption() rpc.ConnOption {
+	return rpc.ConcurrentMethods(concurrentMethods...)
+}
+
 type connSender interface {
 	Notify(ctx context.Context, method string, params any) error
 	Call(ctx context.Context, method string, params, result any) error
@@ -48,15 +48,21 @@ type Server interface {
 	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#textDocument_diagnostic
 	// Diagnostic(context.Context, *DocumentDiagnosticParams) (*DocumentDiagnosticReport, error)
 	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#textDocument_didChange
-	// DidChange(context.Context, *DidChangeTextDocumentParams) error
+	DidChange(context.Context, *DidChangeTextDocumentParams) error
 	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#textDocument_didClose
-	// DidClose(context.Context, *DidCloseTextDocumentParams) error
+	DidClose(context.Context, *DidCloseTextDocumentParams) error
 	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#textDocument_didOpen
 	DidOpen(context.Context, *DidOpenTextDocumentParams) error
 	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#textDocument_didSave
 	DidSave(context.Context, *DidSaveTextDocumentParams) error
 	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#window_workDoneProgress_cancel
-	// WorkDoneProgressCancel(context.Context, *WorkDoneProgressCancelParams) error
+	WorkDoneProgressCancel(context.Context, *WorkDoneProgressCancelParams) error
+	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#workspace_executeCommand
+	ExecuteCommand(context.Context, *ExecuteCommandParams) (any, error)
+	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#workspace_didChangeConfiguration
+	DidChangeConfiguration(context.Context, *DidChangeConfigurationParams) error
+	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#workspace_didChangeWorkspaceFolders
+	DidChangeWorkspaceFolders(context.Context, *DidChangeWorkspaceFoldersParams) error
 	// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification#workspace_diagnostic
 	// DiagnosticWorkspace(context.Context, *WorkspaceDiagnosticParams) (*WorkspaceDiagnosticReport, error)
 }
@@ -97,6 +103,20 @@ func serverDispatch(ctx context.Context, server Server, reply rpc.Replier, r rpc
 		}
 		err := server.DidOpen(ctx, &params)
 		return true, reply(ctx, nil, err)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := UnmarshalJSON(r.Params(), &params); err != nil {
+			return true, sendParseError(ctx, reply, err)
+		}
+		err := server.DidChange(ctx, &params)
+		return true, reply(ctx, nil, err)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := UnmarshalJSON(r.Params(), &params); err != nil {
+			return true, sendParseError(ctx, reply, err)
+		}
+		err := server.DidClose(ctx, &params)
+		return true, reply(ctx, nil, err)
 	case "textDocument/didSave":
 		var params DidSaveTextDocumentParams
 		if err := UnmarshalJSON(r.Params(), &params); err != nil {
@@ -104,6 +124,34 @@ func serverDispatch(ctx context.Context, server Server, reply rpc.Replier, r rpc
 		}
 		err := server.DidSave(ctx, &params)
 		return true, reply(ctx, nil, err)
+	case "window/workDoneProgress/cancel":
+		var params WorkDoneProgressCancelParams
+		if err := UnmarshalJSON(r.Params(), &params); err != nil {
+			return true, sendParseError(ctx, reply, err)
+		}
+		err := server.WorkDoneProgressCancel(ctx, &params)
+		return true, reply(ctx, nil, err)
+	case "workspace/executeCommand":
+		var params ExecuteCommandParams
+		if err := UnmarshalJSON(r.Params(), &params); err != nil {
+			return true, sendParseError(ctx, reply, err)
+		}
+		resp, err := server.ExecuteCommand(ctx, &params)
+		return true, reply(ctx, resp, err)
+	case "workspace/didChangeConfiguration":
+		var params DidChangeConfigurationParams
+		if err := UnmarshalJSON(r.Params(), &params); err != nil {
+			return true, sendParseError(ctx, reply, err)
+		}
+		err := server.DidChangeConfiguration(ctx, &params)
+		return true, reply(ctx, nil, err)
+	case "workspace/didChangeWorkspaceFolders":
+		var params DidChangeWorkspaceFoldersParams
+		if err := UnmarshalJSON(r.Params(), &params); err != nil {
+			return true, sendParseError(ctx, reply, err)
+		}
+		err := server.DidChangeWorkspaceFolders(ctx, &params)
+		return true, reply(ctx, nil, err)
 	case "textDocument/codeAction":
 		var params CodeActionParams
 		if err := UnmarshalJSON(r.Params(), &params); err != nil {
@@ -132,5 +180,5 @@ func serverDispatch(ctx context.Context, server Server, reply rpc.Replier, r rpc
 }
 
 func sendParseError(ctx context.Context, reply rpc.Replier, err error) error {
-	return reply(ctx, nil, fmt.Errorf("%w: %s", rpc.ErrParse, err))
+	return reply(ctx, nil, fmt.Errorf("%s: %w", rpc.ErrParse, err))
 }
@@ -17,6 +17,19 @@ type Diagnostic struct {
 	Source   string             `json:"source"`
 	Message  string             `json:"message"`
 	Data     *json.RawMessage   `json:"data,omitempty"`
+	// An array of related diagnostic information, e.g. when symbol-names within
+	// a scope collide all definitions can be marked via this property.
+	RelatedInformation []DiagnosticRelatedInformation `json:"relatedInformation,omitempty"`
+}
+
+// Represents a related message and source code location for a diagnostic.
+// This should be used to point to code locations that cause or are related to
+// a diagnostics, e.g when duplicating a symbol in a scope.
+type DiagnosticRelatedInformation struct {
+	// The location of this related diagnostic information.
+	Location Location `json:"location"`
+	// The message of this related diagnostic information.
+	Message string `json:"message"`
 }
 
 type TextDocumentDiagnosticsParams struct {
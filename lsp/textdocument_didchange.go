@@ -6,6 +6,9 @@ type DidChangeTextDocumentParams struct {
 }
 
 type TextDocumentContentChangeEvent struct {
-	// The new text of the whole document.
+	// Range is the range of the document that changed. If it is nil, Text is
+	// the new text of the whole document.
+	Range *Range `json:"range,omitempty"`
+	// Text is the new text of Range, or of the whole document.
 	Text string `json:"text"`
 }
@@ -0,0 +1,6 @@
+package lsp
+
+type WorkDoneProgressCancelParams struct {
+	// The token to be used to report progress.
+	Token ProgressToken `json:"token"`
+}
@@ -1,7 +1,7 @@
 package lsp
 
 type WorkDoneProgressReportParams struct {
-	Token string                      `json:"token"`
+	Token ProgressToken               `json:"token"`
 	Value WorkDoneProgressReportValue `json:"value"`
 }
 
@@ -32,5 +32,5 @@ type WorkDoneProgressReportValue struct {
 	 * The value should be steadily rising. Clients are free to ignore values
 	 * that are not following this rule. The value range is [0, 100].
 	 */
-	Percentage int `json:"percentage"`
+	Percentage int `json:"percentage,omitempty"`
 }
@@ -0,0 +1,8 @@
+package lsp
+
+type DidChangeConfigurationParams struct {
+	// Settings are the changed settings. Clients that support
+	// workspace/configuration may send null, in which case the settings
+	// have to be pulled.
+	Settings LSPAny `json:"settings"`
+}
@@ -0,0 +1,23 @@
+package parser
+
+// CSHARP_RESOURCE_QUERY matches resource object creation expressions in .NET
+// programs, e.g.
+//
+//	new Aws.S3.BucketV2("my-bucket", new Aws.S3.BucketV2Args { ... })
+const CSHARP_RESOURCE_QUERY = `
+(object_creation_expression
+  type: [
+    (identifier) @resource_name
+    (qualified_name
+      name: (identifier) @resource_name
+    )
+  ]
+  arguments: (
+    argument_list
+      .
+      (argument (string_literal (string_literal_content) @resource_id))
+      .
+      (argument (object_creation_expression) @object_arg)?
+  )
+) @resource_code
+`
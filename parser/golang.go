@@ -0,0 +1,31 @@
+package parser
+
+// GO_RESOURCE_QUERY matches resource constructor calls in Go programs, e.g.
+//
+//	s3.NewBucketV2(ctx, "my-bucket", &s3.BucketV2Args{...})
+//
+// Go SDK constructors are named New<Type> and always take the
+// *pulumi.Context as their first argument and the logical name second.
+const GO_RESOURCE_QUERY = `
+(call_expression
+  function: [
+    (identifier) @resource_name
+    (selector_expression
+      field: (field_identifier) @resource_name
+    )
+  ]
+  arguments: (
+    argument_list
+      .
+      (identifier)
+      .
+      [
+        (interpreted_string_literal (interpreted_string_literal_content) @resource_id)
+        (raw_string_literal (raw_string_literal_content) @resource_id)
+      ]
+      .
+      (_)? @object_arg
+  )
+  (#match? @resource_name "^New[A-Z]")
+) @resource_code
+`
@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+	tree_sitter_csharp "github.com/tree-sitter/tree-sitter-c-sharp/bindings/go"
+	tree_sitter_go "github.com/tree-sitter/tree-sitter-go/bindings/go"
+	tree_sitter_javascript "github.com/tree-sitter/tree-sitter-javascript/bindings/go"
+	tree_sitter_python "github.com/tree-sitter/tree-sitter-python/bindings/go"
+	tree_sitter_typescript "github.com/tree-sitter/tree-sitter-typescript/bindings/go"
+)
+
+// Language is a Pulumi program language that the parser knows how to
+// extract resources from.
+type Language int
+
+const (
+	// TypeScript programs declare resources with `new` expressions.
+	TypeScript = Language(iota)
+
+	// TSX is TypeScript with JSX syntax, which needs its own grammar.
+	TSX
+
+	// JavaScript programs (including JSX) use the same `new` expressions as
+	// TypeScript.
+	JavaScript
+
+	// Python programs declare resources by calling the resource class.
+	Python
+
+	// Go programs declare resources by calling New<Type> constructors.
+	Go
+
+	// CSharp (.NET) programs declare resources with `new` expressions.
+	CSharp
+)
+
+func (l Language) String() string {
+	switch l {
+	case TypeScript:
+		return "typescript"
+	case TSX:
+		return "tsx"
+	case JavaScript:
+		return "javascript"
+	case Python:
+		return "python"
+	case Go:
+		return "go"
+	case CSharp:
+		return "csharp"
+	default:
+		return fmt.Sprintf("unknown language %d", l)
+	}
+}
+
+// grammar returns the tree-sitter language and the resource query used to
+// parse programs written in l.
+func (l Language) grammar() (*tree_sitter.Language, string, error) {
+	switch l {
+	case TypeScript:
+		return tree_sitter.NewLanguage(tree_sitter_typescript.LanguageTypescript()), RESOURCE_QUERY, nil
+	case TSX:
+		return tree_sitter.NewLanguage(tree_sitter_typescript.LanguageTSX()), RESOURCE_QUERY, nil
+	case JavaScript:
+		return tree_sitter.NewLanguage(tree_sitter_javascript.Language()), RESOURCE_QUERY, nil
+	case Python:
+		return tree_sitter.NewLanguage(tree_sitter_python.Language()), PYTHON_RESOURCE_QUERY, nil
+	case Go:
+		return tree_sitter.NewLanguage(tree_sitter_go.Language()), GO_RESOURCE_QUERY, nil
+	case CSharp:
+		return tree_sitter.NewLanguage(tree_sitter_csharp.Language()), CSHARP_RESOURCE_QUERY, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported language: %s", l)
+	}
+}
+
+// resourceTypeName converts the constructor name captured by the resource
+// query into the resource type name, e.g. "NewBucketV2" => "BucketV2" for Go.
+func (l Language) resourceTypeName(constructor string) string {
+	switch l {
+	case Go:
+		return strings.TrimPrefix(constructor, "New")
+	default:
+		return constructor
+	}
+}
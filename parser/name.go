@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// resourceName returns the resource name for a name node captured by a
+// resource query, and whether that name is only known at runtime.
+func resourceName(node *tree_sitter.Node, source []byte) (string, bool) {
+	text := node.Utf8Text(source)
+	switch node.Kind() {
+	case "template_string":
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			if node.NamedChild(i).Kind() == "template_substitution" {
+				return text, true
+			}
+		}
+		// a template string without substitutions is a plain string
+		return strings.Trim(text, "`"), false
+	case "identifier", "member_expression":
+		return text, true
+	default:
+		return text, false
+	}
+}
+
+// MatchesName reports whether the capture could have declared a resource with
+// the given logical name.
+//
+// Static names must match exactly. Template string names match if the URN
+// name fits the template, with each substitution matching any text. Any
+// other dynamic name (e.g. a variable) matches every name.
+func (c *CaptureInfo) MatchesName(name string) bool {
+	if !c.DynamicName {
+		return c.ResourceName == name
+	}
+	if !strings.HasPrefix(c.ResourceName, "`") {
+		return true
+	}
+	return templatePattern(c.ResourceName).MatchString(name)
+}
+
+// templatePattern converts a template string into a regular expression that
+// matches the strings it can produce.
+func templatePattern(template string) *regexp.Regexp {
+	template = strings.Trim(template, "`")
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for {
+		start := strings.Index(template, "${")
+		if start < 0 {
+			break
+		}
+		pattern.WriteString(regexp.QuoteMeta(template[:start]))
+		pattern.WriteString(".*")
+		// skip to the matching brace, substitutions may contain braces too
+		depth, end := 0, len(template)
+		for i := start + 1; i < len(template); i++ {
+			if template[i] == '{' {
+				depth++
+			} else if template[i] == '}' {
+				depth--
+				if depth == 0 {
+					end = i + 1
+					break
+				}
+			}
+		}
+		template = template[end:]
+	}
+	pattern.WriteString(regexp.QuoteMeta(template))
+	pattern.WriteString("$")
+	return regexp.MustCompile(pattern.String())
+}
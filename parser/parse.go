@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"slices"
 
 	tree_sitter "github.com/tree-sitter/go-tree-sitter"
 )
@@ -16,56 +19,152 @@ const RESOURCE_QUERY = `
   ]
   arguments: (
     arguments
-      (string (string_fragment) @resource_id)?
+      .
+      [
+        (string (string_fragment) @resource_id)
+        (template_string) @resource_id
+        (identifier) @resource_id
+        (member_expression) @resource_id
+      ]
       (object)? @object_arg
   )
 ) @resource_code
 `
 
+// A ResourceNapper extracts resource declarations from programs written in a
+// single language. It is not safe for concurrent use, use a Pool to share
+// nappers between goroutines.
 type ResourceNapper struct {
-	parser *tree_sitter.Parser
-	lang   *tree_sitter.Language
+	parser   *tree_sitter.Parser
+	language Language
+	lang     *tree_sitter.Language
+	query    *tree_sitter.Query
+
+	// documents caches the parse tree of each document parsed with
+	// GetCapturesFromDocument, so unchanged documents are not re-parsed and
+	// changed documents can be parsed incrementally. It is shared by all the
+	// nappers of a Pool, in which case the pool owns it.
+	documents *documentCache
+	pooled    bool
 }
 
 func (r *ResourceNapper) Close() {
+	if !r.pooled {
+		r.documents.clear()
+	}
+	if r.query != nil {
+		r.query.Close()
+	}
 	if r.parser != nil {
 		r.parser.Close()
 	}
 }
 
-func NewResourceNapper(language *tree_sitter.Language) (*ResourceNapper, error) {
+// NewResourceNapper returns a ResourceNapper that extracts resources from
+// programs written in the given language.
+func NewResourceNapper(language Language) (*ResourceNapper, error) {
+	return newResourceNapper(language, newDocumentCache(), false)
+}
+
+func newResourceNapper(language Language, documents *documentCache, pooled bool) (*ResourceNapper, error) {
+	lang, source, err := language.grammar()
+	if err != nil {
+		return nil, err
+	}
+	query, queryErr := tree_sitter.NewQuery(lang, source)
+	if queryErr != nil {
+		return nil, fmt.Errorf("failed to create query: %w", queryErr)
+	}
 	parser := tree_sitter.NewParser()
-	if err := parser.SetLanguage(language); err != nil {
+	if err := parser.SetLanguage(lang); err != nil {
+		query.Close()
 		return nil, fmt.Errorf("failed to set language: %w", err)
 	}
 	parser.StopPrintingDotGraphs()
 	return &ResourceNapper{
-		parser: parser,
-		lang:   language,
+		parser:    parser,
+		language:  language,
+		lang:      lang,
+		query:     query,
+		documents: documents,
+		pooled:    pooled,
 	}, nil
 }
 
 type CaptureInfo struct {
-	ResourceName     string
+	// ResourceName is the logical name the resource is given in the program.
+	// If DynamicName is set, it is the source text of the name expression
+	// instead, e.g. "`${prefix}-logs`" or "name".
+	ResourceName string
+	// DynamicName is set when the resource name is computed at runtime, in
+	// which case it can only be resolved against the URNs from a preview.
+	DynamicName      bool
 	ResourceTypeName string
 	Text             string
 	StartPoint       tree_sitter.Point
 	EndPoint         tree_sitter.Point
+	// Properties are the input properties set in the arguments of the
+	// resource, including those of nested objects. They are only used by the
+	// server, so they aren't sent to the client with the data of diagnostics.
+	Properties []PropertyInfo `json:"-"`
 }
 
+// GetCapturesFromFile parses fileText and returns the resources declared in
+// it. Nothing is cached, see GetCapturesFromDocument.
 func (r *ResourceNapper) GetCapturesFromFile(fileText []byte) ([]CaptureInfo, error) {
 	tree := r.parser.Parse(fileText, nil)
 	defer tree.Close()
-	query, queryErr := tree_sitter.NewQuery(r.lang, RESOURCE_QUERY)
-	if queryErr != nil {
-		return nil, fmt.Errorf("failed to create query: %w", queryErr)
+	return r.captures(tree, fileText)
+}
+
+// GetCapturesFromDocument returns the resources declared in the document
+// identified by key, whose content is fileText with the given hash.
+//
+// The parse tree and captures are cached per document. If the hash matches
+// the cached content, the cached captures are returned as is. Otherwise the
+// difference between the cached and the new content is applied to the cached
+// tree as an edit, so only the changed part of the document is re-parsed.
+// The error of the captures is cached along with them.
+func (r *ResourceNapper) GetCapturesFromDocument(key string, hash [sha256.Size]byte, fileText []byte) ([]CaptureInfo, error) {
+	doc := r.documents.take(key)
+	if doc != nil && doc.hash == hash {
+		r.documents.put(key, doc)
+		return slices.Clone(doc.captures), doc.err
 	}
-	defer query.Close()
 
+	var oldTree *tree_sitter.Tree
+	if doc != nil {
+		oldTree = doc.tree
+		oldTree.Edit(diffEdit(doc.text, fileText))
+	}
+	tree := r.parser.Parse(fileText, oldTree)
+	if oldTree != nil {
+		oldTree.Close()
+	}
+	captures, err := r.captures(tree, fileText)
+	r.documents.put(key, &document{
+		hash:     hash,
+		text:     fileText,
+		tree:     tree,
+		captures: captures,
+		err:      err,
+	})
+	return slices.Clone(captures), err
+}
+
+// Forget drops the cached parse state for the document identified by key,
+// e.g. when it is closed.
+func (r *ResourceNapper) Forget(key string) {
+	r.documents.forget(key)
+}
+
+func (r *ResourceNapper) captures(tree *tree_sitter.Tree, fileText []byte) ([]CaptureInfo, error) {
+	query := r.query
 	captures := []CaptureInfo{}
 
 	cursor := tree_sitter.NewQueryCursor()
-	matches := cursor.Matches(query, tree.RootNode(), nil)
+	defer cursor.Close()
+	matches := cursor.Matches(query, tree.RootNode(), fileText)
 	for {
 		match := matches.Next()
 		if match == nil {
@@ -97,7 +196,7 @@ func (r *ResourceNapper) GetCapturesFromFile(fileText []byte) ([]CaptureInfo, er
 			continue
 		}
 		nameNode := nameNodes[0]
-		info.ResourceTypeName = nameNode.Utf8Text(fileText)
+		info.ResourceTypeName = r.language.resourceTypeName(nameNode.Utf8Text(fileText))
 
 		idIdx, ok := query.CaptureIndexForName("resource_id")
 		if !ok {
@@ -110,7 +209,13 @@ func (r *ResourceNapper) GetCapturesFromFile(fileText []byte) ([]CaptureInfo, er
 			continue
 		}
 		idNode := idNodes[0]
-		info.ResourceName = idNode.Utf8Text(fileText)
+		info.ResourceName, info.DynamicName = resourceName(&idNode, fileText)
+
+		if argIdx, ok := query.CaptureIndexForName("object_arg"); ok {
+			if argNodes := match.NodesForCaptureIndex(argIdx); len(argNodes) == 1 {
+				info.Properties = properties(&argNodes[0], fileText, nil)
+			}
+		}
 
 		captures = append(captures, info)
 	}
@@ -119,3 +224,36 @@ func (r *ResourceNapper) GetCapturesFromFile(fileText []byte) ([]CaptureInfo, er
 	}
 	return captures, nil
 }
+
+// diffEdit returns the edit that turns oldText into newText, assuming the
+// texts differ in a single contiguous region, which is the common case
+// between two versions of a document being edited.
+func diffEdit(oldText, newText []byte) *tree_sitter.InputEdit {
+	limit := min(len(oldText), len(newText))
+	prefix := 0
+	for prefix < limit && oldText[prefix] == newText[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < limit-prefix && oldText[len(oldText)-1-suffix] == newText[len(newText)-1-suffix] {
+		suffix++
+	}
+	oldEnd, newEnd := len(oldText)-suffix, len(newText)-suffix
+	return &tree_sitter.InputEdit{
+		StartByte:      uint(prefix),
+		OldEndByte:     uint(oldEnd),
+		NewEndByte:     uint(newEnd),
+		StartPosition:  pointAt(oldText, prefix),
+		OldEndPosition: pointAt(oldText, oldEnd),
+		NewEndPosition: pointAt(newText, newEnd),
+	}
+}
+
+// pointAt returns the row and (byte) column of offset in text.
+func pointAt(text []byte, offset int) tree_sitter.Point {
+	lineStart := bytes.LastIndexByte(text[:offset], '\n') + 1
+	return tree_sitter.Point{
+		Row:    uint(bytes.Count(text[:offset], []byte("\n"))),
+		Column: uint(offset - lineStart),
+	}
+}
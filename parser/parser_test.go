@@ -1,18 +1,20 @@
 package parser
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/hexops/autogold/v2"
 	"github.com/stretchr/testify/require"
 	tree_sitter "github.com/tree-sitter/go-tree-sitter"
-	tree_sitter_typescript "github.com/tree-sitter/tree-sitter-typescript/bindings/go"
 )
 
 func TestParser(t *testing.T) {
 	text := "import * as aws from '@pulumi/aws';\n\nnew aws.s3.Bucket('my-bucket', {\n  serverSideEncryptionConfiguration: {\n    rule: {\n      applyServerSideEncryptionByDefault: {\n        sseAlgorithm: 'AES256'\n      }\n    }\n  }\n});\n\nnew aws.s3.Bucket('my-bucket2');\n"
-	lang := tree_sitter.NewLanguage(tree_sitter_typescript.LanguageTypescript())
-	napper, err := NewResourceNapper(lang)
+	napper, err := NewResourceNapper(TypeScript)
 	require.NoError(t, err)
 	captures, err := napper.GetCapturesFromFile([]byte(text))
 	require.NoError(t, err)
@@ -29,22 +31,544 @@ func TestParser(t *testing.T) {
       }
     }
   }
-});`,
+})`,
 			StartPoint: tree_sitter.Point{Row: 2},
 			EndPoint: tree_sitter.Point{
 				Row:    10,
-				Column: 3,
+				Column: 2,
+			},
+			Properties: []PropertyInfo{
+				{
+					Path: []string{"serverSideEncryptionConfiguration"},
+					StartPoint: tree_sitter.Point{
+						Row:    3,
+						Column: 2,
+					},
+					EndPoint: tree_sitter.Point{
+						Row:    9,
+						Column: 3,
+					},
+				},
+				{
+					Path: []string{
+						"serverSideEncryptionConfiguration",
+						"rule",
+					},
+					StartPoint: tree_sitter.Point{
+						Row:    4,
+						Column: 4,
+					},
+					EndPoint: tree_sitter.Point{
+						Row:    8,
+						Column: 5,
+					},
+				},
+				{
+					Path: []string{
+						"serverSideEncryptionConfiguration",
+						"rule",
+						"applyServerSideEncryptionByDefault",
+					},
+					StartPoint: tree_sitter.Point{
+						Row:    5,
+						Column: 6,
+					},
+					EndPoint: tree_sitter.Point{
+						Row:    7,
+						Column: 7,
+					},
+				},
+				{
+					Path: []string{
+						"serverSideEncryptionConfiguration",
+						"rule",
+						"applyServerSideEncryptionByDefault",
+						"sseAlgorithm",
+					},
+					StartPoint: tree_sitter.Point{
+						Row:    6,
+						Column: 8,
+					},
+					EndPoint: tree_sitter.Point{
+						Row:    6,
+						Column: 30,
+					},
+				},
 			},
 		},
 		{
 			ResourceName:     "my-bucket2",
 			ResourceTypeName: "Bucket",
-			Text:             "new aws.s3.Bucket('my-bucket2');",
+			Text:             "new aws.s3.Bucket('my-bucket2')",
 			StartPoint:       tree_sitter.Point{Row: 12},
 			EndPoint: tree_sitter.Point{
 				Row:    12,
-				Column: 32,
+				Column: 31,
+			},
+		},
+	}).Equal(t, captures)
+}
+
+func TestPythonParser(t *testing.T) {
+	text := "import pulumi\nimport pulumi_aws as aws\n\nbucket = aws.s3.BucketV2(\"my-bucket\",\n    opts=pulumi.ResourceOptions(protect=True))\n\nother = aws.s3.BucketV2(resource_name=\"my-bucket2\")\n\npulumi.export(\"bucket\", bucket.id)\n"
+	napper, err := NewResourceNapper(Python)
+	require.NoError(t, err)
+	defer napper.Close()
+	captures, err := napper.GetCapturesFromFile([]byte(text))
+	require.NoError(t, err)
+	require.Len(t, captures, 2)
+	autogold.Expect([]CaptureInfo{
+		{
+			ResourceName:     "my-bucket",
+			ResourceTypeName: "BucketV2",
+			Text: `aws.s3.BucketV2("my-bucket",
+    opts=pulumi.ResourceOptions(protect=True))`,
+			StartPoint: tree_sitter.Point{
+				Row:    3,
+				Column: 9,
+			},
+			EndPoint: tree_sitter.Point{
+				Row:    4,
+				Column: 46,
+			},
+		},
+		{
+			ResourceName:     "my-bucket2",
+			ResourceTypeName: "BucketV2",
+			Text:             `aws.s3.BucketV2(resource_name="my-bucket2")`,
+			StartPoint: tree_sitter.Point{
+				Row:    6,
+				Column: 8,
+			},
+			EndPoint: tree_sitter.Point{
+				Row:    6,
+				Column: 51,
+			},
+		},
+	}).Equal(t, captures)
+}
+
+func TestGoParser(t *testing.T) {
+	text := "package main\n\nfunc main() {\n    pulumi.Run(func(ctx *pulumi.Context) error {\n        _, err := s3.NewBucketV2(ctx, \"my-bucket\", &s3.BucketV2Args{\n            ForceDestroy: pulumi.Bool(true),\n        })\n        if err != nil {\n            return err\n        }\n        _, err = s3.NewBucketV2(ctx, `my-bucket2`, nil)\n        return err\n    })\n}\n"
+	napper, err := NewResourceNapper(Go)
+	require.NoError(t, err)
+	defer napper.Close()
+	captures, err := napper.GetCapturesFromFile([]byte(text))
+	require.NoError(t, err)
+	require.Len(t, captures, 2)
+	autogold.Expect([]CaptureInfo{
+		{
+			ResourceName:     "my-bucket",
+			ResourceTypeName: "BucketV2",
+			Text: `s3.NewBucketV2(ctx, "my-bucket", &s3.BucketV2Args{
+            ForceDestroy: pulumi.Bool(true),
+        })`,
+			StartPoint: tree_sitter.Point{
+				Row:    4,
+				Column: 18,
+			},
+			EndPoint: tree_sitter.Point{
+				Row:    6,
+				Column: 10,
+			},
+			Properties: []PropertyInfo{{
+				Path: []string{"ForceDestroy"},
+				StartPoint: tree_sitter.Point{
+					Row:    5,
+					Column: 12,
+				},
+				EndPoint: tree_sitter.Point{
+					Row:    5,
+					Column: 43,
+				},
+			}},
+		},
+		{
+			ResourceName:     "my-bucket2",
+			ResourceTypeName: "BucketV2",
+			Text:             "s3.NewBucketV2(ctx, `my-bucket2`, nil)",
+			StartPoint: tree_sitter.Point{
+				Row:    10,
+				Column: 17,
+			},
+			EndPoint: tree_sitter.Point{
+				Row:    10,
+				Column: 55,
+			},
+		},
+	}).Equal(t, captures)
+}
+
+func TestCSharpParser(t *testing.T) {
+	text := "using Pulumi;\n\nreturn await Deployment.RunAsync(() =>\n{\n    var bucket = new Aws.S3.BucketV2(\"my-bucket\", new Aws.S3.BucketV2Args\n    {\n        ForceDestroy = true,\n    });\n    var other = new BucketV2(\"my-bucket2\");\n});\n"
+	napper, err := NewResourceNapper(CSharp)
+	require.NoError(t, err)
+	defer napper.Close()
+	captures, err := napper.GetCapturesFromFile([]byte(text))
+	require.NoError(t, err)
+	require.Len(t, captures, 2)
+	autogold.Expect([]CaptureInfo{
+		{
+			ResourceName:     "my-bucket",
+			ResourceTypeName: "BucketV2",
+			Text: `new Aws.S3.BucketV2("my-bucket", new Aws.S3.BucketV2Args
+    {
+        ForceDestroy = true,
+    })`,
+			StartPoint: tree_sitter.Point{
+				Row:    4,
+				Column: 17,
+			},
+			EndPoint: tree_sitter.Point{
+				Row:    7,
+				Column: 6,
+			},
+			Properties: []PropertyInfo{{
+				Path: []string{"ForceDestroy"},
+				StartPoint: tree_sitter.Point{
+					Row:    6,
+					Column: 8,
+				},
+				EndPoint: tree_sitter.Point{
+					Row:    6,
+					Column: 27,
+				},
+			}},
+		},
+		{
+			ResourceName:     "my-bucket2",
+			ResourceTypeName: "BucketV2",
+			Text:             `new BucketV2("my-bucket2")`,
+			StartPoint: tree_sitter.Point{
+				Row:    8,
+				Column: 16,
+			},
+			EndPoint: tree_sitter.Point{
+				Row:    8,
+				Column: 42,
+			},
+		},
+	}).Equal(t, captures)
+}
+
+func TestYAMLResourceLocator(t *testing.T) {
+	text := "name: my-project\nruntime: yaml\nresources:\n  my-bucket:\n    type: aws:s3:BucketV2\n    properties:\n      forceDestroy: true\n\n  # the logs bucket\n  my-bucket2:\n    type: aws:s3:BucketV2\noutputs:\n  bucketName: ${my-bucket.id}\n"
+	captures, err := YAMLResourceLocator{}.GetCapturesFromFile([]byte(text))
+	require.NoError(t, err)
+	require.Len(t, captures, 2)
+	autogold.Expect([]CaptureInfo{
+		{
+			ResourceName:     "my-bucket",
+			ResourceTypeName: "BucketV2",
+			Text: `my-bucket:
+    type: aws:s3:BucketV2
+    properties:
+      forceDestroy: true`,
+			StartPoint: tree_sitter.Point{
+				Row:    3,
+				Column: 2,
+			},
+			EndPoint: tree_sitter.Point{
+				Row:    6,
+				Column: 24,
+			},
+		},
+		{
+			ResourceName:     "my-bucket2",
+			ResourceTypeName: "BucketV2",
+			Text:             "my-bucket2:\n    type: aws:s3:BucketV2",
+			StartPoint: tree_sitter.Point{
+				Row:    9,
+				Column: 2,
+			},
+			EndPoint: tree_sitter.Point{
+				Row:    10,
+				Column: 25,
+			},
+		},
+	}).Equal(t, captures)
+}
+
+func TestJavaScriptParser(t *testing.T) {
+	text := "const aws = require('@pulumi/aws');\n\nconst bucket = new aws.s3.BucketV2('my-bucket', {\n  forceDestroy: true,\n});\n"
+	napper, err := NewResourceNapper(JavaScript)
+	require.NoError(t, err)
+	defer napper.Close()
+	captures, err := napper.GetCapturesFromFile([]byte(text))
+	require.NoError(t, err)
+	require.Len(t, captures, 1)
+	autogold.Expect([]CaptureInfo{{
+		ResourceName:     "my-bucket",
+		ResourceTypeName: "BucketV2",
+		Text: `new aws.s3.BucketV2('my-bucket', {
+  forceDestroy: true,
+})`,
+		StartPoint: tree_sitter.Point{
+			Row:    2,
+			Column: 15,
+		},
+		EndPoint: tree_sitter.Point{
+			Row:    4,
+			Column: 2,
+		},
+		Properties: []PropertyInfo{{
+			Path: []string{"forceDestroy"},
+			StartPoint: tree_sitter.Point{
+				Row:    3,
+				Column: 2,
+			},
+			EndPoint: tree_sitter.Point{
+				Row:    3,
+				Column: 20,
+			},
+		}},
+	}}).Equal(t, captures)
+}
+
+func TestTSXParser(t *testing.T) {
+	text := "import * as aws from '@pulumi/aws';\n\nconst label = <span>logs</span>;\nconst bucket = new aws.s3.BucketV2('my-bucket', {\n  forceDestroy: true,\n});\n"
+	napper, err := NewResourceNapper(TSX)
+	require.NoError(t, err)
+	defer napper.Close()
+	captures, err := napper.GetCapturesFromFile([]byte(text))
+	require.NoError(t, err)
+	require.Len(t, captures, 1)
+	autogold.Expect([]CaptureInfo{{
+		ResourceName:     "my-bucket",
+		ResourceTypeName: "BucketV2",
+		Text: `new aws.s3.BucketV2('my-bucket', {
+  forceDestroy: true,
+})`,
+		StartPoint: tree_sitter.Point{
+			Row:    3,
+			Column: 15,
+		},
+		EndPoint: tree_sitter.Point{
+			Row:    5,
+			Column: 2,
+		},
+		Properties: []PropertyInfo{{
+			Path: []string{"forceDestroy"},
+			StartPoint: tree_sitter.Point{
+				Row:    4,
+				Column: 2,
+			},
+			EndPoint: tree_sitter.Point{
+				Row:    4,
+				Column: 20,
+			},
+		}},
+	}}).Equal(t, captures)
+}
+
+func TestParserDynamicNames(t *testing.T) {
+	text := "const prefix = 'app';\nconst name = 'data';\n\nnew aws.s3.BucketV2(`${prefix}-logs`);\nnew aws.s3.BucketV2(name, { forceDestroy: true });\nnew aws.s3.BucketV2(`static`);\n"
+	napper, err := NewResourceNapper(TypeScript)
+	require.NoError(t, err)
+	defer napper.Close()
+	captures, err := napper.GetCapturesFromFile([]byte(text))
+	require.NoError(t, err)
+	require.Len(t, captures, 3)
+	autogold.Expect([]CaptureInfo{
+		{
+			ResourceName:     "`${prefix}-logs`",
+			DynamicName:      true,
+			ResourceTypeName: "BucketV2",
+			Text:             "new aws.s3.BucketV2(`${prefix}-logs`)",
+			StartPoint:       tree_sitter.Point{Row: 3},
+			EndPoint: tree_sitter.Point{
+				Row:    3,
+				Column: 37,
+			},
+		},
+		{
+			ResourceName:     "name",
+			DynamicName:      true,
+			ResourceTypeName: "BucketV2",
+			Text:             "new aws.s3.BucketV2(name, { forceDestroy: true })",
+			StartPoint:       tree_sitter.Point{Row: 4},
+			EndPoint: tree_sitter.Point{
+				Row:    4,
+				Column: 49,
+			},
+			Properties: []PropertyInfo{{
+				Path: []string{"forceDestroy"},
+				StartPoint: tree_sitter.Point{
+					Row:    4,
+					Column: 28,
+				},
+				EndPoint: tree_sitter.Point{
+					Row:    4,
+					Column: 46,
+				},
+			}},
+		},
+		{
+			ResourceName:     "static",
+			ResourceTypeName: "BucketV2",
+			Text:             "new aws.s3.BucketV2(`static`)",
+			StartPoint:       tree_sitter.Point{Row: 5},
+			EndPoint: tree_sitter.Point{
+				Row:    5,
+				Column: 29,
 			},
 		},
 	}).Equal(t, captures)
+
+	require.True(t, captures[0].MatchesName("app-logs"))
+	require.False(t, captures[0].MatchesName("app-logs2"))
+	require.True(t, captures[1].MatchesName("data"))
+	require.True(t, captures[2].MatchesName("static"))
+	require.False(t, captures[2].MatchesName("app-logs"))
+}
+
+func TestGetCapturesFromDocument(t *testing.T) {
+	napper, err := NewResourceNapper(TypeScript)
+	require.NoError(t, err)
+	defer napper.Close()
+
+	before := []byte("new aws.s3.Bucket('my-bucket');\n\nnew aws.s3.Bucket('other');\n")
+	captures, err := napper.GetCapturesFromDocument("index.ts", sha256.Sum256(before), before)
+	require.NoError(t, err)
+	require.Len(t, captures, 2)
+
+	// insert a resource between the two existing ones
+	after := []byte("new aws.s3.Bucket('my-bucket');\nnew aws.s3.BucketV2('inserted', {\n  forceDestroy: true,\n});\n\nnew aws.s3.Bucket('other');\n")
+	captures, err = napper.GetCapturesFromDocument("index.ts", sha256.Sum256(after), after)
+	require.NoError(t, err)
+	expected, err := napper.GetCapturesFromFile(after)
+	require.NoError(t, err)
+	require.Equal(t, expected, captures)
+	require.Len(t, captures, 3)
+	require.Equal(t, "inserted", captures[1].ResourceName)
+	require.Equal(t, uint(5), captures[2].StartPoint.Row)
+
+	napper.Forget("index.ts")
+	require.Empty(t, napper.documents.documents)
+
+	// a document without resources fails the same way when it is cached
+	empty := []byte("export const name = 'app';\n")
+	_, err = napper.GetCapturesFromDocument("empty.ts", sha256.Sum256(empty), empty)
+	require.Error(t, err)
+	_, cachedErr := napper.GetCapturesFromDocument("empty.ts", sha256.Sum256(empty), empty)
+	require.Equal(t, err, cachedErr)
+}
+
+func TestDiffEdit(t *testing.T) {
+	edit := diffEdit([]byte("ab\ncd\nef"), []byte("ab\ncXYd\nef"))
+	autogold.Expect(&tree_sitter.InputEdit{
+		StartByte: 4, OldEndByte: 4, NewEndByte: 6,
+		StartPosition:  tree_sitter.Point{Row: 1, Column: 1},
+		OldEndPosition: tree_sitter.Point{Row: 1, Column: 1},
+		NewEndPosition: tree_sitter.Point{Row: 1, Column: 3},
+	}).Equal(t, edit)
+}
+
+// benchmarkProgram returns a TypeScript program declaring n resources.
+func benchmarkProgram(n int) []byte {
+	var b strings.Builder
+	b.WriteString("import * as aws from '@pulumi/aws';\n\n")
+	for i := range n {
+		fmt.Fprintf(&b, "new aws.s3.BucketV2('bucket-%d', {\n  forceDestroy: true,\n  tags: { index: '%d' },\n});\n\n", i, i)
+	}
+	return []byte(b.String())
+}
+
+func BenchmarkGetCaptures(b *testing.B) {
+	text := benchmarkProgram(500)
+	hash := sha256.Sum256(text)
+	// edited changes a single tag in the middle of the program
+	edited := []byte(strings.Replace(string(text), "index: '250'", "index: '250a'", 1))
+	editedHash := sha256.Sum256(edited)
+
+	napper, err := NewResourceNapper(TypeScript)
+	require.NoError(b, err)
+	defer napper.Close()
+
+	b.Run("cold", func(b *testing.B) {
+		for range b.N {
+			if _, err := napper.GetCapturesFromFile(text); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("warm", func(b *testing.B) {
+		if _, err := napper.GetCapturesFromDocument("warm.ts", hash, text); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for range b.N {
+			if _, err := napper.GetCapturesFromDocument("warm.ts", hash, text); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("incremental", func(b *testing.B) {
+		for i := range b.N {
+			// alternate between the two versions so every iteration is an edit
+			content, contentHash := text, hash
+			if i%2 == 0 {
+				content, contentHash = edited, editedHash
+			}
+			if _, err := napper.GetCapturesFromDocument("incremental.ts", contentHash, content); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestCaptureProperty(t *testing.T) {
+	cases := []struct {
+		language Language
+		text     string
+	}{
+		{TypeScript, "new aws.s3.BucketV2('b', {\n  acl: 'private',\n  lifecycleRules: [\n    { id: 'a' },\n    { id: 'b', enabled: true },\n  ],\n});\n"},
+		{Python, "aws.s3.BucketV2('b',\n  acl='private',\n  lifecycle_rules=[\n    {'id': 'a'},\n    aws.s3.BucketV2LifecycleRuleArgs(id='b', enabled=True),\n  ],\n  opts=pulumi.ResourceOptions(protect=True))\n"},
+		{Go, "s3.NewBucketV2(ctx, \"b\", &s3.BucketV2Args{\n  Acl: pulumi.String(\"private\"),\n  LifecycleRules: s3.BucketV2LifecycleRuleArray{\n    &s3.BucketV2LifecycleRuleArgs{Id: pulumi.String(\"a\")},\n    &s3.BucketV2LifecycleRuleArgs{Id: pulumi.String(\"b\"), Enabled: pulumi.Bool(true)},\n  },\n})\n"},
+		{CSharp, "new Aws.S3.BucketV2(\"b\", new Aws.S3.BucketV2Args {\n  Acl = \"private\",\n  LifecycleRules = new[] {\n    new Aws.S3.Inputs.BucketV2LifecycleRuleArgs { Id = \"a\" },\n    new Aws.S3.Inputs.BucketV2LifecycleRuleArgs { Id = \"b\", Enabled = true },\n  },\n});\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.language.String(), func(t *testing.T) {
+			napper, err := NewResourceNapper(tc.language)
+			require.NoError(t, err)
+			defer napper.Close()
+			captures, err := napper.GetCapturesFromFile([]byte(tc.text))
+			require.NoError(t, err)
+			require.Len(t, captures, 1)
+			capture := captures[0]
+
+			prop, ok := capture.Property("acl")
+			require.True(t, ok)
+			require.Equal(t, uint(1), prop.StartPoint.Row)
+
+			prop, ok = capture.Property("lifecycleRules[1].enabled")
+			require.True(t, ok)
+			require.Equal(t, 3, len(prop.Path))
+			require.Equal(t, uint(4), prop.StartPoint.Row)
+
+			// a missing property is reported on its nearest ancestor
+			prop, ok = capture.Property("lifecycleRules[0].enabled")
+			require.True(t, ok)
+			require.Equal(t, 2, len(prop.Path))
+			require.Equal(t, uint(3), prop.StartPoint.Row)
+
+			_, ok = capture.Property("versioning")
+			require.False(t, ok)
+			_, ok = capture.Property("protect")
+			require.False(t, ok)
+
+			// the properties aren't sent to the client
+			raw, err := json.Marshal(capture)
+			require.NoError(t, err)
+			require.NotContains(t, string(raw), "Properties")
+		})
+	}
+}
+
+func TestParsePropertyPath(t *testing.T) {
+	require.Equal(t, []string{"rules", "0", "id"}, parsePropertyPath("rules[0].id"))
+	require.Equal(t, []string{"tags", "a.b"}, parsePropertyPath(`tags["a.b"]`))
+	require.Equal(t, []string{"acl"}, parsePropertyPath("acl"))
 }
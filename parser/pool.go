@@ -0,0 +1,142 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// A Pool hands out ResourceNappers to concurrent callers.
+//
+// Tree-sitter parsers are not safe for concurrent use, so a napper may only
+// be used by the goroutine that checked it out until it is returned. Nappers
+// are created on demand, so the pool holds as many nappers per language as
+// were ever used at the same time. The parse trees cached by
+// GetCapturesFromDocument are shared by all nappers of the pool.
+type Pool struct {
+	mu     sync.Mutex
+	idle   map[Language][]*ResourceNapper
+	closed bool
+
+	documents *documentCache
+}
+
+// NewPool returns an empty Pool.
+func NewPool() *Pool {
+	return &Pool{
+		idle:      make(map[Language][]*ResourceNapper),
+		documents: newDocumentCache(),
+	}
+}
+
+// Checkout returns a napper for language that is owned by the caller until it
+// is passed to Return.
+func (p *Pool) Checkout(language Language) (*ResourceNapper, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("parser pool is closed")
+	}
+	if idle := p.idle[language]; len(idle) > 0 {
+		napper := idle[len(idle)-1]
+		p.idle[language] = idle[:len(idle)-1]
+		p.mu.Unlock()
+		return napper, nil
+	}
+	p.mu.Unlock()
+	return newResourceNapper(language, p.documents, true)
+}
+
+// Return gives a napper obtained from Checkout back to the pool. The napper
+// must not be used after it is returned.
+func (p *Pool) Return(napper *ResourceNapper) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		napper.Close()
+		return
+	}
+	p.idle[napper.language] = append(p.idle[napper.language], napper)
+}
+
+// Forget drops the cached parse state for the document identified by key.
+func (p *Pool) Forget(key string) {
+	p.documents.forget(key)
+}
+
+// Close closes the idle nappers and the cached parse trees. Nappers that are
+// checked out are closed when they are returned.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, idle := range p.idle {
+		for _, napper := range idle {
+			napper.Close()
+		}
+	}
+	p.idle = nil
+	p.documents.clear()
+}
+
+// document is the cached parse state of a single document.
+type document struct {
+	hash     [sha256.Size]byte
+	text     []byte
+	tree     *tree_sitter.Tree
+	captures []CaptureInfo
+	// err is the error of the captures, e.g. if the document declares no
+	// resources.
+	err error
+}
+
+// documentCache holds the parse state of documents by key.
+//
+// A cached tree is edited in place when its document changes, so a document
+// is taken out of the cache while it is being used and put back afterwards.
+// Concurrent requests for the same document then parse it from scratch
+// rather than sharing the tree.
+type documentCache struct {
+	mu        sync.Mutex
+	documents map[string]*document
+}
+
+func newDocumentCache() *documentCache {
+	return &documentCache{documents: make(map[string]*document)}
+}
+
+// take removes the document for key from the cache and returns it, or nil.
+func (c *documentCache) take(key string) *document {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	doc := c.documents[key]
+	delete(c.documents, key)
+	return doc
+}
+
+// put caches doc for key, replacing any document put there in the meantime.
+func (c *documentCache) put(key string, doc *document) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.documents[key]; ok {
+		old.tree.Close()
+	}
+	c.documents[key] = doc
+}
+
+func (c *documentCache) forget(key string) {
+	if doc := c.take(key); doc != nil {
+		doc.tree.Close()
+	}
+}
+
+func (c *documentCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, doc := range c.documents {
+		doc.tree.Close()
+		delete(c.documents, key)
+	}
+}
@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPoolConcurrent hammers a pool from many goroutines, run it with -race.
+func TestPoolConcurrent(t *testing.T) {
+	pool := NewPool()
+	defer pool.Close()
+
+	programs := map[Language]string{
+		TypeScript: "new aws.s3.BucketV2('bucket-%d');\n",
+		Python:     "aws.s3.BucketV2('bucket-%d')\n",
+		Go:         "package main\n\nfunc main() {\n  s3.NewBucketV2(ctx, \"bucket-%d\", nil)\n}\n",
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for worker := range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				lang := []Language{TypeScript, Python, Go}[(worker+i)%3]
+				text := []byte(fmt.Sprintf(programs[lang], i%5))
+				// a handful of documents per language, so that workers
+				// share (and edit) cached documents
+				key := fmt.Sprintf("%s-%d", lang, worker%4)
+
+				napper, err := pool.Checkout(lang)
+				if err != nil {
+					errs <- err
+					return
+				}
+				captures, err := napper.GetCapturesFromDocument(key, sha256.Sum256(text), text)
+				pool.Return(napper)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(captures) != 1 || captures[0].ResourceName != fmt.Sprintf("bucket-%d", i%5) {
+					errs <- fmt.Errorf("%s: unexpected captures %+v", key, captures)
+					return
+				}
+				if i%10 == 0 {
+					pool.Forget(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestPoolClosed(t *testing.T) {
+	pool := NewPool()
+	napper, err := pool.Checkout(TypeScript)
+	require.NoError(t, err)
+	pool.Close()
+	// returning after close closes the napper rather than pooling it
+	pool.Return(napper)
+	_, err = pool.Checkout(TypeScript)
+	require.Error(t, err)
+}
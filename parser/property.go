@@ -0,0 +1,212 @@
+package parser
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// PropertyInfo is an input property set in the arguments of a resource.
+type PropertyInfo struct {
+	// Path is the path of the property from the arguments of the resource as
+	// it is written in the program, e.g. ["rules", "0", "id"] for the id of
+	// the first element of the rules property.
+	Path       []string
+	StartPoint tree_sitter.Point
+	EndPoint   tree_sitter.Point
+}
+
+// pythonConstructorArgs are the keyword arguments of Python resource
+// constructors that are not inputs of the resource.
+var pythonConstructorArgs = []string{"resource_name", "opts"}
+
+// properties returns the properties set in the argument node of a resource
+// and in the objects nested in it, with their paths below prefix.
+func properties(node *tree_sitter.Node, source []byte, prefix []string) []PropertyInfo {
+	if node == nil {
+		return nil
+	}
+	var props []PropertyInfo
+	property := func(element *tree_sitter.Node, key string, value *tree_sitter.Node) {
+		path := append(slices.Clone(prefix), key)
+		props = append(props, PropertyInfo{
+			Path:       path,
+			StartPoint: element.StartPosition(),
+			EndPoint:   element.EndPosition(),
+		})
+		props = append(props, properties(value, source, path)...)
+	}
+	elements := func(node *tree_sitter.Node) {
+		index := 0
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			element := node.NamedChild(i)
+			if element.Kind() == "comment" {
+				continue
+			}
+			property(element, strconv.Itoa(index), element)
+			index++
+		}
+	}
+
+	switch node.Kind() {
+	// TypeScript and JavaScript
+	case "object":
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			child := node.NamedChild(i)
+			switch child.Kind() {
+			case "pair":
+				key := child.ChildByFieldName("key")
+				if key == nil || key.Kind() == "computed_property_name" {
+					continue
+				}
+				property(child, propertyKey(key, source), child.ChildByFieldName("value"))
+			case "shorthand_property_identifier":
+				property(child, child.Utf8Text(source), nil)
+			}
+		}
+	case "array", "list":
+		elements(node)
+
+	// Python
+	case "argument_list":
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			child := node.NamedChild(i)
+			switch child.Kind() {
+			case "keyword_argument":
+				name := child.ChildByFieldName("name").Utf8Text(source)
+				value := child.ChildByFieldName("value")
+				switch {
+				case slices.Contains(pythonConstructorArgs, name):
+				case name == "args" && len(prefix) == 0:
+					// the inputs are given as an args class
+					props = append(props, properties(value, source, prefix)...)
+				default:
+					property(child, name, value)
+				}
+			case "call":
+				if len(prefix) == 0 {
+					props = append(props, properties(child, source, prefix)...)
+				}
+			}
+		}
+	case "call":
+		props = append(props, properties(node.ChildByFieldName("arguments"), source, prefix)...)
+	case "dictionary":
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			child := node.NamedChild(i)
+			if child.Kind() == "pair" {
+				property(child, propertyKey(child.ChildByFieldName("key"), source), child.ChildByFieldName("value"))
+			}
+		}
+
+	// Go
+	case "unary_expression":
+		props = append(props, properties(node.ChildByFieldName("operand"), source, prefix)...)
+	case "composite_literal":
+		props = append(props, properties(node.ChildByFieldName("body"), source, prefix)...)
+	case "literal_element":
+		props = append(props, properties(node.NamedChild(0), source, prefix)...)
+	case "literal_value":
+		if namedChild(node, "keyed_element") == nil {
+			// a slice or array
+			elements(node)
+			break
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			child := node.NamedChild(i)
+			if child.Kind() == "keyed_element" {
+				key := child.ChildByFieldName("key").NamedChild(0)
+				property(child, propertyKey(key, source), child.ChildByFieldName("value"))
+			}
+		}
+
+	// C#
+	case "object_creation_expression", "implicit_object_creation_expression",
+		"array_creation_expression", "implicit_array_creation_expression":
+		props = append(props, properties(namedChild(node, "initializer_expression"), source, prefix)...)
+	case "initializer_expression":
+		if namedChild(node, "assignment_expression") == nil {
+			// a collection initializer
+			elements(node)
+			break
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			child := node.NamedChild(i)
+			if child.Kind() == "assignment_expression" {
+				property(child, propertyKey(child.ChildByFieldName("left"), source), child.ChildByFieldName("right"))
+			}
+		}
+	}
+	return props
+}
+
+// namedChild returns the first named child of node of the given kind, or nil
+// if there is none.
+func namedChild(node *tree_sitter.Node, kind string) *tree_sitter.Node {
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		if child := node.NamedChild(i); child.Kind() == kind {
+			return child
+		}
+	}
+	return nil
+}
+
+// propertyKey returns the name of the property whose key is node, without
+// the quotes of string keys.
+func propertyKey(node *tree_sitter.Node, source []byte) string {
+	if node == nil {
+		return ""
+	}
+	return strings.Trim(node.Utf8Text(source), "\"'`")
+}
+
+// propertyPathSegment matches a segment of a property path, e.g. "rules",
+// "[0]" or `["key.with.dots"]`.
+var propertyPathSegment = regexp.MustCompile(`\["((?:[^"\\]|\\.)*)"\]|\[(\d+)\]|([^.\[]+)`)
+
+// parsePropertyPath returns the segments of a property path as reported by
+// providers, e.g. "rules[0].id" is ["rules", "0", "id"].
+func parsePropertyPath(path string) []string {
+	var segments []string
+	for _, m := range propertyPathSegment.FindAllStringSubmatch(path, -1) {
+		switch {
+		case m[1] != "":
+			segments = append(segments, m[1])
+		case m[2] != "":
+			segments = append(segments, m[2])
+		default:
+			segments = append(segments, m[3])
+		}
+	}
+	return segments
+}
+
+// Property returns the innermost property set in the arguments of the
+// resource that the property path of a provider refers to, e.g.
+// "versioning.enabled". If the property itself isn't set, e.g. because it is
+// missing, it returns the nearest ancestor that is set. Names are compared
+// ignoring case and underscores, since programs name properties in the
+// conventions of their language.
+func (c *CaptureInfo) Property(path string) (PropertyInfo, bool) {
+	segments := parsePropertyPath(path)
+	var best PropertyInfo
+	found := false
+	for _, prop := range c.Properties {
+		if len(prop.Path) > len(segments) || (found && len(prop.Path) <= len(best.Path)) {
+			continue
+		}
+		if slices.EqualFunc(prop.Path, segments[:len(prop.Path)], func(a, b string) bool {
+			return normalizePropertyName(a) == normalizePropertyName(b)
+		}) {
+			best, found = prop, true
+		}
+	}
+	return best, found
+}
+
+func normalizePropertyName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
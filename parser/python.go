@@ -0,0 +1,44 @@
+package parser
+
+// PYTHON_RESOURCE_QUERY matches resource constructor calls in Python
+// programs, e.g.
+//
+//	aws.s3.BucketV2("my-bucket", opts=pulumi.ResourceOptions(...))
+//	aws.s3.BucketV2(resource_name="my-bucket")
+//
+// Python has no `new` keyword, so any call to a capitalized callable whose
+// name is given as a string is treated as a resource.
+const PYTHON_RESOURCE_QUERY = `
+(call
+  function: [
+    (identifier) @resource_name
+    (attribute
+      attribute: (identifier) @resource_name
+    )
+  ]
+  arguments: (
+    argument_list
+      .
+      (string (string_content) @resource_id)
+  ) @object_arg
+  (#match? @resource_name "^[A-Z]")
+) @resource_code
+
+(call
+  function: [
+    (identifier) @resource_name
+    (attribute
+      attribute: (identifier) @resource_name
+    )
+  ]
+  arguments: (
+    argument_list
+      (keyword_argument
+        name: (identifier) @_keyword
+        value: (string (string_content) @resource_id)
+      )
+  ) @object_arg
+  (#eq? @_keyword "resource_name")
+  (#match? @resource_name "^[A-Z]")
+) @resource_code
+`
@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLResourceLocator finds resource declarations in Pulumi YAML programs.
+//
+// Pulumi YAML has no constructor expressions to query for, resources are
+// entries in the top level `resources` mapping keyed by their logical name:
+//
+//	resources:
+//	  my-bucket:
+//	    type: aws:s3:BucketV2
+//	    properties:
+//	      forceDestroy: true
+//
+// Each entry is captured from the start of its key to the end of its last
+// non-blank line, so the capture covers the whole resource block.
+type YAMLResourceLocator struct{}
+
+// GetCapturesFromFile returns a capture for every entry in the `resources`
+// section of the given Pulumi YAML program.
+func (YAMLResourceLocator) GetCapturesFromFile(fileText []byte) ([]CaptureInfo, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(fileText, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse yaml: %w", err)
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("no match found")
+	}
+	lines := bytes.Split(fileText, []byte("\n"))
+
+	root := doc.Content[0]
+	var resources *yaml.Node
+	// sectionEnd is the row of the first top level key after `resources`
+	sectionEnd := len(lines)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if resources != nil {
+			sectionEnd = root.Content[i].Line - 1
+			break
+		}
+		if root.Content[i].Value == "resources" {
+			resources = root.Content[i+1]
+		}
+	}
+	if resources == nil || resources.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("no match found")
+	}
+
+	captures := []CaptureInfo{}
+	for i := 0; i+1 < len(resources.Content); i += 2 {
+		key, value := resources.Content[i], resources.Content[i+1]
+		limit := sectionEnd
+		if i+2 < len(resources.Content) {
+			limit = resources.Content[i+2].Line - 1
+		}
+
+		start := tree_sitter.Point{Row: uint(key.Line - 1), Column: uint(key.Column - 1)}
+		end := lastContentPoint(lines, start.Row, uint(limit))
+		captures = append(captures, CaptureInfo{
+			ResourceName:     key.Value,
+			ResourceTypeName: yamlResourceTypeName(value),
+			Text:             pointsText(lines, start, end),
+			StartPoint:       start,
+			EndPoint:         end,
+		})
+	}
+	if len(captures) == 0 {
+		return nil, fmt.Errorf("no match found")
+	}
+	return captures, nil
+}
+
+// yamlResourceTypeName returns the type name from the `type` token of a
+// resource entry, e.g. "aws:s3:BucketV2" => "BucketV2".
+func yamlResourceTypeName(value *yaml.Node) string {
+	if value.Kind != yaml.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		if value.Content[i].Value == "type" {
+			token := value.Content[i+1].Value
+			return token[strings.LastIndex(token, ":")+1:]
+		}
+	}
+	return ""
+}
+
+// lastContentPoint returns the end of the last line in [start, limit) that is
+// not blank or a comment. Trailing comments usually belong to the next entry.
+func lastContentPoint(lines [][]byte, start, limit uint) tree_sitter.Point {
+	limit = min(limit, uint(len(lines)))
+	end := start
+	for row := start; row < limit; row++ {
+		trimmed := bytes.TrimSpace(lines[row])
+		if len(trimmed) == 0 || trimmed[0] == '#' {
+			continue
+		}
+		end = row
+	}
+	return tree_sitter.Point{Row: end, Column: uint(len(bytes.TrimRight(lines[end], " \t\r")))}
+}
+
+func pointsText(lines [][]byte, start, end tree_sitter.Point) string {
+	if start.Row == end.Row {
+		return string(lines[start.Row][start.Column:end.Column])
+	}
+	text := [][]byte{lines[start.Row][start.Column:]}
+	text = append(text, lines[start.Row+1:end.Row]...)
+	text = append(text, lines[end.Row][:end.Column])
+	return string(bytes.Join(text, []byte("\n")))
+}
@@ -21,7 +21,7 @@ type Configuration struct {
 // Property represents a property in the configuration.
 type Property struct {
 	Type                []string `json:"type"`
-	Default             *string  `json:"default"`
+	Default             any      `json:"default"`
 	MarkdownDescription string   `json:"markdownDescription"`
 }
 
@@ -90,6 +90,41 @@ func NewVscodeProject(project projen.Project) typescript.TypeScriptProject {
 					Default:             StrPtr("info"),
 					MarkdownDescription: "The log level for the Pulumi LSP. Can be one of 'debug', 'info', 'warn', or 'error'.",
 				},
+				"pulumilsp.stack": {
+					Type:                []string{"string"},
+					Default:             StrPtr(""),
+					MarkdownDescription: "The stack to preview, e.g. 'dev'. Defaults to the stack that is selected with `pulumi stack select`. Can be set per workspace folder.",
+				},
+				"pulumilsp.trigger": {
+					Type:                []string{"string"},
+					Default:             StrPtr("onSave"),
+					MarkdownDescription: "When to run previews. 'onSave' previews when a file is saved, 'onChange' also previews unsaved changes while typing.",
+				},
+				"pulumilsp.previewDelay": {
+					Type:                []string{"string"},
+					Default:             StrPtr("1s"),
+					MarkdownDescription: "How long to wait after the last change before previewing unsaved changes, e.g. '500ms'. Only used if `pulumilsp.trigger` is 'onChange'.",
+				},
+				"pulumilsp.policyPacks": {
+					Type:                []string{"array"},
+					Default:             []string{},
+					MarkdownDescription: "Paths of policy packs to run previews with, in addition to those required for the stack. Relative paths are relative to the project.",
+				},
+				"pulumilsp.policyPackConfigs": {
+					Type:                []string{"object"},
+					Default:             map[string]string{},
+					MarkdownDescription: "Paths of JSON config files of policy packs, by the path of the pack as it is written in `pulumilsp.policyPacks`. Packs without a config file use their default config.",
+				},
+				"pulumilsp.enabledPolicies": {
+					Type:                []string{"array"},
+					Default:             []string{},
+					MarkdownDescription: "Names of the policies whose violations are reported. Violations of all policies are reported if empty.",
+				},
+				"pulumilsp.aiProvider": {
+					Type:                []string{"string"},
+					Default:             StrPtr(""),
+					MarkdownDescription: "The provider used to fix violations, 'copilot' or 'openai'. Chosen based on the violation if empty.",
+				},
 			},
 		},
 	})
@@ -0,0 +1,283 @@
+package pulumicommand
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/corymhall/pulumilsp/debug"
+	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// policyAnalyzers are the plugins the CLI runs policy packs with, for Node.js
+// and Python policy packs.
+var policyAnalyzers = []string{"pulumi-analyzer-policy", "pulumi-analyzer-policy-python"}
+
+// analyzerProxy captures the calls the CLI makes to the policy pack analyzers
+// of a preview. The CLI looks for analyzer plugins on the PATH first, so the
+// proxy puts shims of the policy analyzers on the PATH of the preview. A shim
+// runs the real analyzer and registers it with the proxy, which serves the
+// CLI in its place, forwarding every call and recording the results of the
+// analyzer calls in the store.
+type analyzerProxy struct {
+	store    *ResourceStore
+	listener net.Listener
+	// dir holds the shims.
+	dir string
+
+	mu      sync.Mutex
+	servers []*grpc.Server
+	conns   []*grpc.ClientConn
+	closed  bool
+}
+
+// newAnalyzerProxy starts a proxy recording into store and installs the shims
+// of the policy analyzers that are installed.
+func newAnalyzerProxy(ctx context.Context, store *ResourceStore) (*analyzerProxy, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("analyzer shims are not supported on windows")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("error finding the pulumilsp executable: %w", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("error listening for analyzers: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "pulumilsp-analyzers-")
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error creating shim dir: %w", err)
+	}
+	p := &analyzerProxy{
+		store:    store,
+		listener: listener,
+		dir:      dir,
+	}
+	installed := 0
+	for _, name := range policyAnalyzers {
+		analyzer, err := findAnalyzer(name)
+		if err != nil {
+			debug.Debug.Log(ctx, "Not proxying analyzer", "analyzer", name, "error", err)
+			continue
+		}
+		script := analyzerShimScript(executable, listener.Addr().String(), analyzer)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			p.close()
+			return nil, fmt.Errorf("error writing analyzer shim: %w", err)
+		}
+		installed++
+	}
+	if installed == 0 {
+		p.close()
+		return nil, errors.New("no policy analyzers are installed")
+	}
+	go p.serve(ctx)
+	return p, nil
+}
+
+// findAnalyzer returns the path of the analyzer plugin with the given name,
+// which is on the PATH or next to the pulumi executable.
+func findAnalyzer(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	pulumi, err := exec.LookPath("pulumi")
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(pulumi); err == nil {
+		pulumi = resolved
+	}
+	return exec.LookPath(filepath.Join(filepath.Dir(pulumi), name))
+}
+
+// analyzerShimScript returns a script that runs analyzer through the
+// analyzer shim command of executable.
+func analyzerShimScript(executable, proxyAddr, analyzer string) string {
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	return fmt.Sprintf("#!/bin/sh\nexec %s %s %s %s \"$@\"\n",
+		quote(executable), AnalyzerShimCommand, quote(proxyAddr), quote(analyzer))
+}
+
+// path returns the PATH the preview runs with, with the shims first.
+func (p *analyzerProxy) path() string {
+	return p.dir + string(os.PathListSeparator) + os.Getenv("PATH")
+}
+
+// serve accepts registrations of analyzers until the proxy is closed. A shim
+// registers an analyzer by writing its port and the directory of its policy
+// pack on a line each, and the proxy replies with the port the CLI should
+// connect to instead.
+func (p *analyzerProxy) serve(ctx context.Context) {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		if err := p.register(ctx, conn); err != nil {
+			debug.LogError(ctx, "error registering analyzer", err)
+		}
+		conn.Close()
+	}
+}
+
+func (p *analyzerProxy) register(ctx context.Context, conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+	port, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	dir, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	dir = strings.TrimSpace(dir)
+	client, err := grpc.NewClient("127.0.0.1:"+strings.TrimSpace(port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		client.Close()
+		return err
+	}
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+			return p.forward(ctx, client, dir, stream)
+		}),
+	)
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		listener.Close()
+		client.Close()
+		return errors.New("analyzer proxy is closed")
+	}
+	p.servers = append(p.servers, server)
+	p.conns = append(p.conns, client)
+	p.mu.Unlock()
+	p.store.setAnalyzersProxied()
+
+	go server.Serve(listener) //nolint:errcheck
+	_, err = fmt.Fprintf(conn, "%d\n", listener.Addr().(*net.TCPAddr).Port)
+	return err
+}
+
+// forward forwards a call of the CLI to the analyzer of the policy pack in
+// dir and records its result.
+func (p *analyzerProxy) forward(ctx context.Context, client *grpc.ClientConn, dir string, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	var request []byte
+	if err := stream.RecvMsg(&request); err != nil {
+		return err
+	}
+	callCtx := stream.Context()
+	if md, ok := metadata.FromIncomingContext(callCtx); ok {
+		callCtx = metadata.NewOutgoingContext(callCtx, md)
+	}
+	var response []byte
+	if err := client.Invoke(callCtx, method, &request, &response, grpc.ForceCodec(rawCodec{})); err != nil {
+		return err
+	}
+	if err := p.record(ctx, method, dir, request, response); err != nil {
+		debug.LogError(ctx, fmt.Sprintf("error recording %s call", method), err)
+	}
+	return stream.SendMsg(&response)
+}
+
+// record records the result of a successful call of the analyzer of the
+// policy pack in dir in the store.
+func (p *analyzerProxy) record(ctx context.Context, method, dir string, rawRequest, rawResponse []byte) error {
+	switch method {
+	case "/pulumirpc.Analyzer/Analyze":
+		var request rpc.AnalyzeRequest
+		var response rpc.AnalyzeResponse
+		if err := unmarshalCall(rawRequest, &request, rawResponse, &response); err != nil {
+			return err
+		}
+		recordAnalyze(ctx, p.store, &request, &response)
+	case "/pulumirpc.Analyzer/AnalyzeStack":
+		var request rpc.AnalyzeStackRequest
+		var response rpc.AnalyzeResponse
+		if err := unmarshalCall(rawRequest, &request, rawResponse, &response); err != nil {
+			return err
+		}
+		recordAnalyzeStack(ctx, p.store, &response)
+	case "/pulumirpc.Analyzer/GetAnalyzerInfo":
+		var response rpc.AnalyzerInfo
+		if err := unmarshalCall(rawRequest, &emptypb.Empty{}, rawResponse, &response); err != nil {
+			return err
+		}
+		p.store.addPolicyPack(dir, &response)
+	}
+	return nil
+}
+
+func unmarshalCall(rawRequest []byte, request proto.Message, rawResponse []byte, response proto.Message) error {
+	if err := proto.Unmarshal(rawRequest, request); err != nil {
+		return err
+	}
+	return proto.Unmarshal(rawResponse, response)
+}
+
+// close stops serving the CLI and removes the shims.
+func (p *analyzerProxy) close() {
+	p.mu.Lock()
+	p.closed = true
+	servers, conns := p.servers, p.conns
+	p.mu.Unlock()
+
+	p.listener.Close()
+	for _, server := range servers {
+		server.Stop()
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	os.RemoveAll(p.dir)
+}
+
+// rawCodec passes messages through as they are encoded on the wire, so calls
+// are forwarded without knowing their types.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return *msg, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*msg = append((*msg)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	// the messages are protobuf encoded, only the codec doesn't decode them
+	return "proto"
+}
@@ -0,0 +1,153 @@
+package pulumicommand
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type fakeAnalyzer struct {
+	rpc.UnimplementedAnalyzerServer
+}
+
+func (fakeAnalyzer) Analyze(_ context.Context, req *rpc.AnalyzeRequest) (*rpc.AnalyzeResponse, error) {
+	if req.Name == "broken" {
+		return nil, status.Error(codes.InvalidArgument, "broken resource")
+	}
+	return &rpc.AnalyzeResponse{Diagnostics: []*rpc.AnalyzeDiagnostic{{
+		PolicyName: "no-public-buckets",
+		Message:    "bucket is public",
+		Urn:        req.Urn,
+	}}}, nil
+}
+
+func (fakeAnalyzer) GetAnalyzerInfo(context.Context, *emptypb.Empty) (*rpc.AnalyzerInfo, error) {
+	return &rpc.AnalyzerInfo{Name: "aws-policies"}, nil
+}
+
+func (fakeAnalyzer) GetPluginInfo(context.Context, *emptypb.Empty) (*rpc.PluginInfo, error) {
+	return &rpc.PluginInfo{Version: "1.2.3"}, nil
+}
+
+func TestAnalyzerProxy(t *testing.T) {
+	ctx := context.Background()
+	analyzerListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	analyzer := grpc.NewServer()
+	rpc.RegisterAnalyzerServer(analyzer, fakeAnalyzer{})
+	go analyzer.Serve(analyzerListener) //nolint:errcheck
+	defer analyzer.Stop()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	store := &ResourceStore{}
+	proxy := &analyzerProxy{store: store, listener: listener, dir: t.TempDir()}
+	go proxy.serve(ctx)
+	defer proxy.close()
+
+	port, err := registerAnalyzer(listener.Addr().String(), strconv.Itoa(analyzerListener.Addr().(*net.TCPAddr).Port), "/app/policies")
+	require.NoError(t, err)
+	require.True(t, store.proxiesAnalyzers())
+
+	conn, err := grpc.NewClient("127.0.0.1:"+port, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := rpc.NewAnalyzerClient(conn)
+
+	info, err := client.GetAnalyzerInfo(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Equal(t, "aws-policies", info.Name)
+
+	bucket := "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs"
+	resp, err := client.Analyze(ctx, &rpc.AnalyzeRequest{Urn: bucket, Name: "logs"})
+	require.NoError(t, err)
+	require.Len(t, resp.Diagnostics, 1)
+
+	// errors and calls that aren't recorded are forwarded as is
+	_, err = client.Analyze(ctx, &rpc.AnalyzeRequest{Urn: "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::broken", Name: "broken"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	plugin, err := client.GetPluginInfo(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Equal(t, "1.2.3", plugin.Version)
+
+	result := store.result()
+	require.Len(t, result.Analyzers, 1)
+	require.Equal(t, "aws-policies", result.Analyzers[0].Name)
+	require.Same(t, result.Analyzers[0], result.PolicyPacks["/app/policies"])
+	require.Len(t, result.Resources, 1)
+	require.Equal(t, "bucket is public", result.Resources[bucket].Diagnostics[0].Message)
+
+	// the gRPC log doesn't record the calls a second time
+	evt := GrpcEntry{}
+	evt.Method = "/pulumirpc.Analyzer/GetAnalyzerInfo"
+	evt.Response = []byte(`{"name": "aws-policies"}`)
+	handleGrpcEvent(ctx, evt, store)
+	require.Len(t, store.result().Analyzers, 1)
+}
+
+func TestAnalyzerShimScript(t *testing.T) {
+	script := analyzerShimScript("/opt/pulumi lsp/pulumilsp", "127.0.0.1:4000", "/usr/bin/it's-pulumi-analyzer-policy")
+	require.Equal(t, "#!/bin/sh\nexec '/opt/pulumi lsp/pulumilsp' analyzer-shim '127.0.0.1:4000' '/usr/bin/it'\\''s-pulumi-analyzer-policy' \"$@\"\n", script)
+}
+
+func TestAnalyzerProxyWithUnproxiedAnalyzer(t *testing.T) {
+	ctx := context.Background()
+	var progress []Progress
+	store := &ResourceStore{onProgress: func(p Progress) { progress = append(progress, p) }}
+	bucket := "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs"
+
+	store.registerResource(bucket, &rpc.SourcePosition{Uri: "file:///app/index.ts", Line: 3}, "")
+
+	// the proxy serves the analyzer of the aws-policies pack
+	store.setAnalyzersProxied()
+	store.addAnalyzer(&rpc.AnalyzerInfo{Name: "aws-policies"})
+	recordAnalyze(ctx, store, &rpc.AnalyzeRequest{Urn: bucket}, &rpc.AnalyzeResponse{Diagnostics: []*rpc.AnalyzeDiagnostic{{
+		PolicyPackName: "aws-policies",
+		PolicyName:     "no-public-buckets",
+		Message:        "bucket is public",
+		Urn:            bucket,
+	}}})
+
+	// the gRPC log has the calls of both analyzers
+	event := func(method, request, response string) GrpcEntry {
+		evt := GrpcEntry{}
+		evt.Method = method
+		evt.Request = []byte(request)
+		evt.Response = []byte(response)
+		return evt
+	}
+	for _, evt := range []GrpcEntry{
+		event("/pulumirpc.Analyzer/GetAnalyzerInfo", `{}`, `{"name": "aws-policies"}`),
+		event("/pulumirpc.Analyzer/GetAnalyzerInfo", `{}`, `{"name": "tag-policies"}`),
+		event("/pulumirpc.Analyzer/Analyze", `{"urn": "`+bucket+`"}`, `{"diagnostics": [
+			{"policyPackName": "aws-policies", "policyName": "no-public-buckets", "message": "bucket is public", "urn": "`+bucket+`"}
+		]}`),
+		event("/pulumirpc.Analyzer/Analyze", `{"urn": "`+bucket+`"}`, `{"diagnostics": [
+			{"policyPackName": "tag-policies", "policyName": "required-tags", "message": "bucket has no tags", "urn": "`+bucket+`"}
+		]}`),
+	} {
+		handleGrpcEvent(ctx, evt, store)
+	}
+
+	// the call recorded by the proxy isn't reported again
+	require.Len(t, progress, 3)
+	require.Equal(t, 1, progress[2].Analyzed)
+	require.Len(t, progress[2].Resource.Diagnostics, 2)
+
+	result := store.result()
+	require.Len(t, result.Analyzers, 2)
+	require.Equal(t, "tag-policies", result.Analyzers[1].Name)
+	diagnostics := result.Resources[bucket].Diagnostics
+	require.Len(t, diagnostics, 2)
+	require.Equal(t, "no-public-buckets", diagnostics[0].PolicyName)
+	require.Equal(t, "required-tags", diagnostics[1].PolicyName)
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/corymhall/pulumilsp/debug"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
 )
 
 type Runner struct {
@@ -24,6 +25,11 @@ func New(stack auto.Stack) *Runner {
 	}
 }
 
+// StackName returns the name of the stack the runner previews.
+func (r *Runner) StackName() string {
+	return r.stack.Name()
+}
+
 func (r *Runner) initialize() {
 	r.once.Do(func() {
 		r.inFlight = make(chan struct{}, 1)
@@ -31,7 +37,7 @@ func (r *Runner) initialize() {
 	})
 }
 
-func (r *Runner) Run(ctx context.Context) (map[string]*ResourceInfo, error) {
+func (r *Runner) Run(ctx context.Context, opts ...optpreview.Option) (*Result, error) {
 	r.initialize()
 	ctx, done := debug.Start(ctx, "pulumicommand.Run")
 	defer done()
@@ -51,9 +57,9 @@ func (r *Runner) Run(ctx context.Context) (map[string]*ResourceInfo, error) {
 	case r.inFlight <- struct{}{}:
 		defer func() { <-r.inFlight }()
 	}
-	res, err := run(ctx, r.stack)
+	res, err := run(ctx, r.stack, opts...)
 	if err != nil {
 		debug.LogError(ctx, "error running pulumi command", err)
 	}
-	return res, nil
+	return res, err
 }
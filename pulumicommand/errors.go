@@ -0,0 +1,273 @@
+package pulumicommand
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/corymhall/pulumilsp/debug"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/urn"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// EngineError is an error that made the preview fail, e.g. a resource whose
+// inputs were rejected by its provider, or an exception in the program.
+type EngineError struct {
+	// URN is the resource the error is about, if any.
+	URN urn.URN
+	// SourcePosition is where the resource was registered, if known.
+	SourcePosition *rpc.SourcePosition
+	// Property is the path of the input property of the resource the error
+	// is about, e.g. "versioning.enabled", if any.
+	Property string
+	Message  string
+	// Frames is the stack trace of a program exception, innermost first.
+	Frames []StackFrame
+}
+
+// StackFrame is a frame of the stack trace of a program exception.
+type StackFrame struct {
+	URI string
+	// Line and Column are 1-based. Column is 0 if unknown.
+	Line   int
+	Column int
+}
+
+// failedRegistration is a RegisterResource call that failed, e.g. because
+// the inputs of the resource were invalid.
+type failedRegistration struct {
+	request *rpc.RegisterResourceRequest
+	message string
+}
+
+// checkFailure is a Check call of a provider that rejected the inputs of a
+// resource. If the call itself failed, it has a single failure without a
+// property.
+type checkFailure struct {
+	urn      urn.URN
+	failures []*rpc.CheckFailure
+}
+
+func (r *ResourceStore) addFailedRegistration(request *rpc.RegisterResourceRequest, message string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failedRegistrations = append(r.failedRegistrations, failedRegistration{request, message})
+}
+
+func (r *ResourceStore) addCheckFailure(resourceURN urn.URN, failures []*rpc.CheckFailure) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.checkFailures = append(r.checkFailures, checkFailure{resourceURN, failures})
+}
+
+// engineErrorsLocked returns the errors of the failed Check and
+// RegisterResource calls, one for each input a provider rejected. A failed
+// check also fails the registration of the resource, so it is only reported
+// once.
+func (r *ResourceStore) engineErrorsLocked() []*EngineError {
+	var errs []*EngineError
+	reported := make(map[int]bool)
+	for _, check := range r.checkFailures {
+		var sourcePosition *rpc.SourcePosition
+		if info, ok := r.Resources[string(check.urn)]; ok {
+			sourcePosition = info.SourcePosition
+		}
+		for i, reg := range r.failedRegistrations {
+			if reg.request.Type == string(check.urn.Type()) && reg.request.Name == check.urn.Name() {
+				reported[i] = true
+				if sourcePosition == nil {
+					sourcePosition = reg.request.SourcePosition
+				}
+			}
+		}
+		for _, failure := range check.failures {
+			msg := failure.Reason
+			if failure.Property != "" {
+				msg = fmt.Sprintf("%s: %s", failure.Property, failure.Reason)
+			}
+			errs = append(errs, &EngineError{
+				URN:            check.urn,
+				SourcePosition: sourcePosition,
+				Property:       failure.Property,
+				Message:        msg,
+			})
+		}
+	}
+	for i, reg := range r.failedRegistrations {
+		if reported[i] {
+			continue
+		}
+		errs = append(errs, &EngineError{
+			URN:            r.registrationURNLocked(reg.request),
+			SourcePosition: reg.request.SourcePosition,
+			Message:        reg.message,
+		})
+	}
+	return errs
+}
+
+// registrationURNLocked returns the URN of the resource registered by
+// request, which isn't reported if the registration fails.
+func (r *ResourceStore) registrationURNLocked(request *rpc.RegisterResourceRequest) urn.URN {
+	parent := urn.URN(request.Parent)
+	if !parent.IsValid() {
+		// the stack and project are the same for all resources
+		for resourceURN := range r.Resources {
+			parent = urn.URN(resourceURN)
+			break
+		}
+	}
+	if !parent.IsValid() {
+		return ""
+	}
+	return urn.New(parent.Stack(), parent.Project(), parent.QualifiedType(), tokens.Type(request.Type), request.Name)
+}
+
+func handleFailedRegisterResource(ctx context.Context, evt GrpcEntry, store *ResourceStore) {
+	var request rpc.RegisterResourceRequest
+	if err := unmarshalMessage(evt.Request, &request); err != nil {
+		debug.LogError(ctx, "Error unmarshalling register resource request", err)
+		return
+	}
+	store.addFailedRegistration(&request, grpcErrorMessage(evt.Errors))
+}
+
+func handleCheck(ctx context.Context, evt GrpcEntry, store *ResourceStore) {
+	var request rpc.CheckRequest
+	if err := unmarshalMessage(evt.Request, &request); err != nil {
+		debug.LogError(ctx, "Error unmarshalling check request", err)
+		return
+	}
+	if len(evt.Errors) > 0 {
+		store.addCheckFailure(urn.URN(request.Urn), []*rpc.CheckFailure{{Reason: grpcErrorMessage(evt.Errors)}})
+		return
+	}
+	var response rpc.CheckResponse
+	if err := unmarshalMessage(evt.Response, &response); err != nil {
+		debug.LogError(ctx, "Error unmarshalling check response", err)
+		return
+	}
+	if len(response.Failures) > 0 {
+		store.addCheckFailure(urn.URN(request.Urn), response.Failures)
+	}
+}
+
+// grpcErrorMessage returns the message of the errors of a gRPC call, without
+// the status code.
+func grpcErrorMessage(errs []string) string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		if _, desc, ok := strings.Cut(err, " desc = "); ok {
+			err = desc
+		}
+		messages = append(messages, err)
+	}
+	return strings.Join(messages, "\n")
+}
+
+var (
+	// e.g. "    at Object.<anonymous> (/app/index.ts:12:5)" or "    at /app/index.ts:12:5"
+	nodeFrame = regexp.MustCompile(`^\s+at (?:.* \()?(/[^()]+?):(\d+):(\d+)\)?$`)
+	// e.g. `  File "/app/__main__.py", line 12, in <module>`
+	pythonFrame = regexp.MustCompile(`^\s+File "(/[^"]+)", line (\d+)`)
+	// e.g. "   at Program.<Main>$(String[] args) in /app/Program.cs:line 12"
+	dotnetFrame = regexp.MustCompile(`^\s+at .* in (/.+):line (\d+)$`)
+
+	// e.g. "error: Program failed with an unhandled exception:", or
+	// "Unhandled exception. System.Exception: boom" on .NET
+	unhandledException = regexp.MustCompile(`(?i)unhandled exception[.:]?\s*(.*)$`)
+)
+
+// programErrors returns the unhandled exceptions of the program in the output
+// of a failed preview, with their stack traces.
+func programErrors(output string) []*EngineError {
+	var errs []*EngineError
+	var current *EngineError
+	var message []string
+	// the line after a Python frame is the source line, not the message
+	skipSource := false
+	// Python prints the innermost frame last
+	innermostLast := false
+	finish := func() {
+		if current != nil {
+			if innermostLast {
+				slices.Reverse(current.Frames)
+			}
+			current.Message = strings.Join(message, "\n")
+			if current.Message != "" || len(current.Frames) > 0 {
+				errs = append(errs, current)
+			}
+		}
+		current, message, innermostLast = nil, nil, false
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if m := unhandledException.FindStringSubmatch(trimmed); m != nil {
+			finish()
+			current = &EngineError{}
+			if m[1] != "" {
+				message = append(message, m[1])
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "error: ") {
+			// the end of the exception, or another diagnostic
+			finish()
+			continue
+		}
+
+		if frame, ok := parseStackFrame(line); ok {
+			current.Frames = append(current.Frames, frame)
+			skipSource = pythonFrame.MatchString(line)
+			continue
+		}
+		if skipSource {
+			skipSource = false
+			continue
+		}
+		if strings.HasPrefix(trimmed, "at ") {
+			// a frame without a file, e.g. of the runtime itself
+			continue
+		}
+		if trimmed == "Traceback (most recent call last):" {
+			innermostLast = true
+			continue
+		}
+		message = append(message, trimmed)
+	}
+	finish()
+	return errs
+}
+
+// parseStackFrame parses a frame of a Node.js, Python or .NET stack trace.
+func parseStackFrame(line string) (StackFrame, bool) {
+	for _, re := range []*regexp.Regexp{nodeFrame, pythonFrame, dotnetFrame} {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		frame := StackFrame{URI: fileURI(m[1])}
+		frame.Line, _ = strconv.Atoi(m[2])
+		if len(m) > 3 {
+			frame.Column, _ = strconv.Atoi(m[3])
+		}
+		return frame, true
+	}
+	return StackFrame{}, false
+}
+
+func fileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
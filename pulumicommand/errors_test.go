@@ -0,0 +1,124 @@
+package pulumicommand
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgramErrors(t *testing.T) {
+	t.Run("nodejs", func(t *testing.T) {
+		output := `Diagnostics:
+  pulumi:pulumi:Stack (proj-dev):
+    error: Running program '/app' failed with an unhandled exception:
+    TypeError: Cannot read properties of undefined (reading 'id')
+        at Object.<anonymous> (/app/lib/bucket.ts:12:25)
+        at /app/index.ts:3:1
+        at Module._compile (node:internal/modules/cjs/loader:1256:14)
+
+Resources:
+    + 1 to create
+`
+		require.Equal(t, []*EngineError{{
+			Message: "TypeError: Cannot read properties of undefined (reading 'id')",
+			Frames: []StackFrame{
+				{URI: "file:///app/lib/bucket.ts", Line: 12, Column: 25},
+				{URI: "file:///app/index.ts", Line: 3, Column: 1},
+			},
+		}}, programErrors(output))
+	})
+
+	t.Run("python", func(t *testing.T) {
+		output := `    error: Program failed with an unhandled exception:
+    Traceback (most recent call last):
+      File "/app/__main__.py", line 5, in <module>
+        import infra
+      File "/app/infra.py", line 9, in <module>
+        bucket = s3.Bucket(nam="logs")
+    TypeError: Bucket._internal_init() got an unexpected keyword argument 'nam'
+`
+		require.Equal(t, []*EngineError{{
+			Message: "TypeError: Bucket._internal_init() got an unexpected keyword argument 'nam'",
+			Frames: []StackFrame{
+				{URI: "file:///app/infra.py", Line: 9},
+				{URI: "file:///app/__main__.py", Line: 5},
+			},
+		}}, programErrors(output))
+	})
+
+	t.Run("dotnet", func(t *testing.T) {
+		output := `    Unhandled exception. System.Exception: boom
+       at Program.<Main>$(String[] args) in /app/Program.cs:line 14
+`
+		require.Equal(t, []*EngineError{{
+			Message: "System.Exception: boom",
+			Frames:  []StackFrame{{URI: "file:///app/Program.cs", Line: 14}},
+		}}, programErrors(output))
+	})
+
+	t.Run("no exception", func(t *testing.T) {
+		require.Empty(t, programErrors("    error: preview failed\n"))
+	})
+}
+
+func TestEngineErrors(t *testing.T) {
+	store := &ResourceStore{}
+	stack := "urn:pulumi:dev::proj::pulumi:pulumi:Stack::proj-dev"
+	store.getOrCreateResourceInfo(stack)
+	logsPos := &rpc.SourcePosition{Uri: "file:///app/index.ts", Line: 4}
+	store.addFailedRegistration(&rpc.RegisterResourceRequest{
+		Type: "aws:s3/bucketV2:BucketV2", Name: "logs", Parent: stack, SourcePosition: logsPos,
+	}, "resource has invalid inputs")
+	sitePos := &rpc.SourcePosition{Uri: "file:///app/index.ts", Line: 9}
+	store.addFailedRegistration(&rpc.RegisterResourceRequest{
+		Type: "my:index:Site", Name: "web", SourcePosition: sitePos,
+	}, "component failed")
+	store.addCheckFailure("urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs", []*rpc.CheckFailure{
+		{Property: "acl", Reason: "invalid value"},
+		{Property: "tags", Reason: "expected a map"},
+	})
+
+	require.Equal(t, []*EngineError{
+		{
+			URN:            "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs",
+			SourcePosition: logsPos,
+			Property:       "acl",
+			Message:        "acl: invalid value",
+		},
+		{
+			URN:            "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs",
+			SourcePosition: logsPos,
+			Property:       "tags",
+			Message:        "tags: expected a map",
+		},
+		{
+			URN:            "urn:pulumi:dev::proj::my:index:Site::web",
+			SourcePosition: sitePos,
+			Message:        "component failed",
+		},
+	}, store.result().Errors)
+}
+
+func TestHandleCheck(t *testing.T) {
+	store := &ResourceStore{}
+	var evt GrpcEntry
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"method": "/pulumirpc.ResourceProvider/Check",
+		"request": {"urn": "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs", "news": {"acl": "public"}},
+		"response": {"failures": [{"property": "acl", "reason": "expected one of private, public-read"}]}
+	}`), &evt))
+	handleCheck(context.Background(), evt, store)
+
+	require.Equal(t, []*EngineError{{
+		URN:      "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs",
+		Property: "acl",
+		Message:  "acl: expected one of private, public-read",
+	}}, store.result().Errors)
+}
+
+func TestGrpcErrorMessage(t *testing.T) {
+	require.Equal(t, "bucket name is taken", grpcErrorMessage([]string{"rpc error: code = Unknown desc = bucket name is taken"}))
+}
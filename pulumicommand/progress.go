@@ -0,0 +1,55 @@
+package pulumicommand
+
+import (
+	"slices"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+)
+
+// Progress is reported while a preview runs, each time a resource is
+// registered or the policy packs analyze a resource.
+type Progress struct {
+	// Registered is the number of resources registered so far.
+	Registered int
+	// Analyzed is the number of resources analyzed so far.
+	Analyzed int
+	// Resource is a copy of the resource that was analyzed, with the policy
+	// violations reported so far. It is nil if no resource was analyzed, or
+	// if the registration of the resource hasn't completed yet, in which case
+	// progress is reported again once it has.
+	Resource *ResourceInfo
+}
+
+// OnProgress returns an option that calls f with the progress of the
+// preview, so results can be used before the preview completes. f is called
+// while the events of the preview are handled, so it must not block.
+func OnProgress(f func(Progress)) optpreview.Option {
+	return progressOption(f)
+}
+
+type progressOption func(Progress)
+
+// ApplyOption implements optpreview.Option. The option is handled by the
+// runner rather than the preview.
+func (progressOption) ApplyOption(*optpreview.Options) {}
+
+// progressLocked returns the progress to report, with a copy of the analyzed
+// resource info if it isn't nil.
+func (r *ResourceStore) progressLocked(info *ResourceInfo) *Progress {
+	if r.onProgress == nil {
+		return nil
+	}
+	progress := &Progress{Registered: r.registered, Analyzed: len(r.analyzed)}
+	if info != nil && info.SourcePosition != nil {
+		resource := *info
+		resource.Diagnostics = slices.Clone(info.Diagnostics)
+		progress.Resource = &resource
+	}
+	return progress
+}
+
+func (r *ResourceStore) reportProgress(progress *Progress) {
+	if progress != nil {
+		r.onProgress(*progress)
+	}
+}
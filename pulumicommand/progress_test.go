@@ -0,0 +1,76 @@
+package pulumicommand
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	ctx := context.Background()
+	var progress []Progress
+	store := &ResourceStore{onProgress: func(p Progress) { progress = append(progress, p) }}
+	bucket := "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs"
+	queue := "urn:pulumi:dev::proj::aws:sqs/queue:Queue::jobs"
+	pos := &rpc.SourcePosition{Uri: "file:///app/index.ts", Line: 3}
+
+	// registered before it is analyzed
+	store.registerResource(queue, pos, "")
+	require.Equal(t, []Progress{{Registered: 1}}, progress)
+	recordAnalyze(ctx, store, &rpc.AnalyzeRequest{Urn: queue}, &rpc.AnalyzeResponse{})
+	require.Len(t, progress, 2)
+	require.Equal(t, 1, progress[1].Registered)
+	require.Equal(t, 1, progress[1].Analyzed)
+	require.Equal(t, queue, string(progress[1].Resource.URN))
+	require.Empty(t, progress[1].Resource.Diagnostics)
+
+	// analyzed before its registration completes
+	recordAnalyze(ctx, store, &rpc.AnalyzeRequest{Urn: bucket}, &rpc.AnalyzeResponse{
+		Diagnostics: []*rpc.AnalyzeDiagnostic{{PolicyName: "no-public-buckets", Urn: bucket}},
+	})
+	require.Equal(t, Progress{Registered: 1, Analyzed: 2}, progress[2])
+	store.registerResource(bucket, pos, "")
+	require.Len(t, progress, 4)
+	require.Equal(t, 2, progress[3].Registered)
+	require.Equal(t, 2, progress[3].Analyzed)
+	require.Equal(t, "no-public-buckets", progress[3].Resource.Diagnostics[0].PolicyName)
+
+	// the reported resources are copies
+	store.addDiagnostic(&rpc.AnalyzeDiagnostic{PolicyName: "tagged", Urn: bucket})
+	require.Len(t, progress[3].Resource.Diagnostics, 1)
+}
+
+func TestProgressInOrder(t *testing.T) {
+	ctx := context.Background()
+	var progress []Progress
+	store := &ResourceStore{onProgress: func(p Progress) { progress = append(progress, p) }}
+	pos := &rpc.SourcePosition{Uri: "file:///app/index.ts", Line: 3}
+
+	// resources are registered by the engine, and analyzed by the proxy
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range 100 {
+			store.registerResource(fmt.Sprintf("urn:pulumi:dev::proj::aws:sqs/queue:Queue::q%d", i), pos, "")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := range 100 {
+			recordAnalyze(ctx, store, &rpc.AnalyzeRequest{Urn: fmt.Sprintf("urn:pulumi:dev::proj::aws:sqs/queue:Queue::q%d", i)}, &rpc.AnalyzeResponse{})
+		}
+	}()
+	wg.Wait()
+
+	require.Len(t, progress, 200)
+	for i := 1; i < len(progress); i++ {
+		require.GreaterOrEqual(t, progress[i].Registered, progress[i-1].Registered)
+		require.GreaterOrEqual(t, progress[i].Analyzed, progress[i-1].Analyzed)
+	}
+	require.Equal(t, Progress{Registered: 100, Analyzed: 100}, Progress{Registered: progress[199].Registered, Analyzed: progress[199].Analyzed})
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sync"
 
 	"github.com/corymhall/pulumilsp/debug"
@@ -14,31 +15,80 @@ import (
 	"github.com/pulumi/providertest/grpclog"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/urn"
 	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
 	jsonpb "google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 /**
 * TODOS:
 * TODO: Move the resourcestore into its own package and make resources private
-* TODO: add handling errors that occur during preview. e.g. Failures during check, etc
-*       should be processed as diagnostics
  */
 
 type ResourceStore struct {
 	mutex     sync.Mutex
 	Resources map[string]*ResourceInfo
+	Analyzers []*rpc.AnalyzerInfo
+	// policyPacks are the analyzers recorded by the analyzer proxy, by the
+	// directory of their policy pack.
+	policyPacks map[string]*rpc.AnalyzerInfo
+
+	failedRegistrations []failedRegistration
+	checkFailures       []checkFailure
+	// analyzersProxied is set once an analyzer is served by the analyzer
+	// proxy. The calls of the analyzers it serves are recorded by the proxy,
+	// so only the results of other analyzers are recorded from the gRPC log.
+	analyzersProxied bool
+
+	// registered is the number of resources registered so far, and analyzed
+	// are the URNs of the resources analyzed so far.
+	registered int
+	analyzed   map[string]bool
+	onProgress func(Progress)
+	// reportMu serializes the progress reports, which are made by the
+	// analyzer proxy and the reader of the gRPC log, so that they are
+	// reported in the order they were made.
+	reportMu sync.Mutex
+}
+
+// Result is the result of a preview.
+type Result struct {
+	// Resources are the resources registered by the program, by URN.
+	Resources map[string]*ResourceInfo
+	// Analyzers describe the policy packs that were loaded, including the
+	// config schemas of their policies.
+	Analyzers []*rpc.AnalyzerInfo
+	// PolicyPacks are the Analyzers whose policy pack is known, by the
+	// directory of the pack. Analyzers that aren't proxied are missing.
+	PolicyPacks map[string]*rpc.AnalyzerInfo
+	// Errors are the errors that made the preview fail.
+	Errors []*EngineError
 }
 type ResourceInfo struct {
+	URN urn.URN
+	// Parent is the URN of the resource's parent, e.g. the component
+	// resource that created it, or the stack.
+	Parent         urn.URN
 	SourcePosition *rpc.SourcePosition
 	Diagnostics    []*rpc.AnalyzeDiagnostic
 }
 
+// LogicalName returns the name the resource was given in the program.
+func (r *ResourceInfo) LogicalName() string {
+	return r.URN.Name()
+}
+
 func (r *ResourceInfo) SetSourcePosition(pos *rpc.SourcePosition) {
 	r.SourcePosition = pos
 }
 
+func (r *ResourceInfo) SetParent(parent urn.URN) {
+	r.Parent = parent
+}
+
 func (r *ResourceInfo) AddDiagnostic(diagnostic *rpc.AnalyzeDiagnostic) {
 	r.Diagnostics = append(r.Diagnostics, diagnostic)
 }
@@ -54,20 +104,165 @@ func (r *ResourceStore) GetResourceInfo(urn string) (*ResourceInfo, bool) {
 	return info, ok
 }
 
-func (r *ResourceStore) getOrCreateResourceInfo(urn string) *ResourceInfo {
+func (r *ResourceStore) addAnalyzer(info *rpc.AnalyzerInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Analyzers = append(r.Analyzers, info)
+}
+
+// addPolicyPack records the analyzer of the policy pack in dir. If dir is
+// empty, the analyzer is recorded without its pack.
+func (r *ResourceStore) addPolicyPack(dir string, info *rpc.AnalyzerInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Analyzers = append(r.Analyzers, info)
+	if dir == "" {
+		return
+	}
+	if r.policyPacks == nil {
+		r.policyPacks = make(map[string]*rpc.AnalyzerInfo)
+	}
+	r.policyPacks[filepath.Clean(dir)] = info
+}
+
+// registerResource records where a resource was registered and reports the
+// progress, including the resource if it was analyzed before its
+// registration completed.
+func (r *ResourceStore) registerResource(resourceURN string, sourcePosition *rpc.SourcePosition, parent urn.URN) {
+	r.reportMu.Lock()
+	defer r.reportMu.Unlock()
+	r.mutex.Lock()
+	info := r.getOrCreateResourceInfoLocked(resourceURN)
+	info.SetSourcePosition(sourcePosition)
+	info.SetParent(parent)
+	r.registered++
+	if !r.analyzed[resourceURN] {
+		info = nil
+	}
+	progress := r.progressLocked(info)
+	r.mutex.Unlock()
+	r.reportProgress(progress)
+}
+
+// analyzeResource records the policy violations of a resource reported by
+// an Analyze call. They are added to those reported by other policy packs.
+func (r *ResourceStore) analyzeResource(resourceURN string, diagnostics []*rpc.AnalyzeDiagnostic) {
+	r.reportMu.Lock()
+	defer r.reportMu.Unlock()
+	r.mutex.Lock()
+	info := r.getOrCreateResourceInfoLocked(resourceURN)
+	for _, diagnostic := range diagnostics {
+		info.AddDiagnostic(diagnostic)
+	}
+	if r.analyzed == nil {
+		r.analyzed = make(map[string]bool)
+	}
+	r.analyzed[resourceURN] = true
+	progress := r.progressLocked(info)
+	r.mutex.Unlock()
+	r.reportProgress(progress)
+}
+
+// addDiagnostic adds a policy violation of the resource it is about.
+func (r *ResourceStore) addDiagnostic(diagnostic *rpc.AnalyzeDiagnostic) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.getOrCreateResourceInfoLocked(diagnostic.Urn).AddDiagnostic(diagnostic)
+}
+
+func (r *ResourceStore) setAnalyzersProxied() {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
+	r.analyzersProxied = true
+}
+
+func (r *ResourceStore) proxiesAnalyzers() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.analyzersProxied
+}
+
+// unrecordedDiagnostics returns the policy violations from the gRPC log that
+// the analyzer proxy didn't record already, i.e. those of analyzers it
+// doesn't serve. Violations without a URN are about resourceURN.
+func (r *ResourceStore) unrecordedDiagnostics(resourceURN string, diagnostics []*rpc.AnalyzeDiagnostic) []*rpc.AnalyzeDiagnostic {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.analyzersProxied {
+		return diagnostics
+	}
+	var unrecorded []*rpc.AnalyzeDiagnostic
+	for _, diagnostic := range diagnostics {
+		urn := diagnostic.Urn
+		if urn == "" {
+			urn = resourceURN
+		}
+		var recorded []*rpc.AnalyzeDiagnostic
+		if info, ok := r.Resources[urn]; ok {
+			recorded = info.Diagnostics
+		}
+		if !slices.ContainsFunc(recorded, func(d *rpc.AnalyzeDiagnostic) bool { return proto.Equal(d, diagnostic) }) {
+			unrecorded = append(unrecorded, diagnostic)
+		}
+	}
+	return unrecorded
+}
+
+// analyzeRecorded reports whether the analyzer proxy recorded an Analyze
+// call of a resource already.
+func (r *ResourceStore) analyzeRecorded(resourceURN string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.analyzersProxied && r.analyzed[resourceURN]
+}
+
+// analyzerRecorded reports whether the analyzer proxy recorded the info of
+// an analyzer already.
+func (r *ResourceStore) analyzerRecorded(info *rpc.AnalyzerInfo) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.analyzersProxied && slices.ContainsFunc(r.Analyzers, func(a *rpc.AnalyzerInfo) bool { return proto.Equal(a, info) })
+}
+
+func (r *ResourceStore) result() *Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return &Result{
+		Resources:   r.Resources,
+		Analyzers:   r.Analyzers,
+		PolicyPacks: r.policyPacks,
+		Errors:      r.engineErrorsLocked(),
+	}
+}
+
+func (r *ResourceStore) getOrCreateResourceInfo(resourceURN string) *ResourceInfo {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.getOrCreateResourceInfoLocked(resourceURN)
+}
+
+func (r *ResourceStore) getOrCreateResourceInfoLocked(resourceURN string) *ResourceInfo {
 	if r.Resources == nil {
 		r.Resources = map[string]*ResourceInfo{}
 	}
-	if _, ok := r.Resources[urn]; !ok {
-		r.Resources[urn] = &ResourceInfo{}
+	if _, ok := r.Resources[resourceURN]; !ok {
+		r.Resources[resourceURN] = &ResourceInfo{URN: urn.URN(resourceURN)}
 	}
-	return r.Resources[urn]
+	return r.Resources[resourceURN]
 }
 
-func run(ctx context.Context, stack auto.Stack) (map[string]*ResourceInfo, error) {
+func run(ctx context.Context, stack auto.Stack, opts ...optpreview.Option) (*Result, error) {
+	opts, cleanup, err := withPolicyPackConfigs(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
 	store := &ResourceStore{}
+	for _, opt := range opts {
+		if onProgress, ok := opt.(progressOption); ok {
+			store.onProgress = onProgress
+		}
+	}
 	events := make(chan GrpcEntry)
 
 	f, err := setupLogTailing("preview", events)
@@ -76,41 +271,68 @@ func run(ctx context.Context, stack auto.Stack) (map[string]*ResourceInfo, error
 	}
 	defer f.Close()
 	ctx, _ = debug.Start(ctx, "pulumi.preview", "filename", f.Filename)
-	go processGrpcEvents(ctx, events, store)
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+		processGrpcEvents(ctx, events, store)
+	}()
 
 	stack.Workspace().SetEnvVar("PULUMI_DEBUG_GRPC", f.Filename)
 
-	_, err = stack.Preview(ctx, optpreview.SuppressProgress())
-	return store.Resources, err
+	// policy violations are captured by the analyzer proxy if possible, the
+	// gRPC log is only used for the calls of analyzers it can't proxy
+	proxy, err := newAnalyzerProxy(ctx, store)
+	if err != nil {
+		debug.Debug.Log(ctx, "Reading analyzer calls from the gRPC log", "reason", err)
+	} else {
+		defer proxy.close()
+		stack.Workspace().SetEnvVar("PATH", proxy.path())
+		defer stack.Workspace().UnsetEnvVar("PATH")
+	}
+
+	opts = append([]optpreview.Option{optpreview.SuppressProgress()}, opts...)
+	_, err = stack.Preview(ctx, opts...)
+
+	// process the rest of the log before reading the results
+	f.Close()
+	<-processed
+	result := store.result()
+	if err != nil {
+		// program exceptions are only reported in the output
+		result.Errors = append(result.Errors, programErrors(err.Error())...)
+	}
+	return result, err
 }
 
+// processGrpcEvents handles events until the log is closed. Events are
+// drained without handling them once ctx is cancelled, so the log can be
+// closed.
 func processGrpcEvents(ctx context.Context, events <-chan GrpcEntry, store *ResourceStore) {
-	for {
-		select {
-		case <-ctx.Done():
-			debug.Debug.Log(ctx, "Stopping processGrpcEvents due to context cancellation")
-			return
-		case evt, ok := <-events:
-			if !ok {
-				debug.Debug.Log(ctx, "Events channel closed, stopping processGrpcEvents")
-				return
-			}
-			handleGrpcEvent(ctx, evt, store)
+	for evt := range events {
+		if ctx.Err() != nil {
+			continue
 		}
+		handleGrpcEvent(ctx, evt, store)
 	}
+	debug.Debug.Log(ctx, "Events channel closed, stopping processGrpcEvents")
 }
 
 func handleGrpcEvent(ctx context.Context, evt GrpcEntry, store *ResourceStore) {
 	switch evt.Method {
 	case "/pulumirpc.ResourceMonitor/RegisterResource":
 		debug.Debug.Log(ctx, "RegisterResource event")
-		handleRegisterResource(ctx, evt, store)
-	case "/pulumirpc.Analyzer/AnalyzeStack":
-		debug.Debug.Log(ctx, "AnalyzeStack event")
-		handleAnalyzeStack(ctx, evt, store)
-	case "/pulumirpc.Analyzer/Analyze":
-		debug.Debug.Log(ctx, "AnalyzeStack event")
-		handleAnalyze(ctx, evt, store)
+		if len(evt.Errors) > 0 {
+			handleFailedRegisterResource(ctx, evt, store)
+		} else {
+			handleRegisterResource(ctx, evt, store)
+		}
+	case "/pulumirpc.ResourceProvider/Check":
+		debug.Debug.Log(ctx, "Check event")
+		handleCheck(ctx, evt, store)
+	case "/pulumirpc.Analyzer/AnalyzeStack",
+		"/pulumirpc.Analyzer/Analyze",
+		"/pulumirpc.Analyzer/GetAnalyzerInfo":
+		handleAnalyzerEvent(ctx, evt, store)
 	default:
 		// Unhandled method
 	}
@@ -122,7 +344,21 @@ func handleRegisterResource(ctx context.Context, evt GrpcEntry, store *ResourceS
 		debug.LogError(ctx, "Error unmarshalling register resource entry", err)
 		return
 	}
-	store.getOrCreateResourceInfo(tEntry.Response.Urn).SetSourcePosition(tEntry.Request.SourcePosition)
+	store.registerResource(tEntry.Response.Urn, tEntry.Request.SourcePosition, urn.URN(tEntry.Request.Parent))
+}
+
+func handleAnalyzerEvent(ctx context.Context, evt GrpcEntry, store *ResourceStore) {
+	switch evt.Method {
+	case "/pulumirpc.Analyzer/AnalyzeStack":
+		debug.Debug.Log(ctx, "AnalyzeStack event")
+		handleAnalyzeStack(ctx, evt, store)
+	case "/pulumirpc.Analyzer/Analyze":
+		debug.Debug.Log(ctx, "Analyze event")
+		handleAnalyze(ctx, evt, store)
+	case "/pulumirpc.Analyzer/GetAnalyzerInfo":
+		debug.Debug.Log(ctx, "GetAnalyzerInfo event")
+		handleGetAnalyzerInfo(ctx, evt, store)
+	}
 }
 
 func handleAnalyzeStack(ctx context.Context, evt GrpcEntry, store *ResourceStore) {
@@ -131,13 +367,11 @@ func handleAnalyzeStack(ctx context.Context, evt GrpcEntry, store *ResourceStore
 		debug.LogError(ctx, "Error unmarshalling analyze stack entry", err)
 		return
 	}
-	if tEntry.Response.Diagnostics == nil {
-		debug.Debug.Log(ctx, "No diagnostics found in analyze stack response for Stack")
-		return
-	}
-	for _, d := range tEntry.Response.Diagnostics {
-		store.getOrCreateResourceInfo(d.Urn).AddDiagnostic(d)
+	if tEntry.Response.Diagnostics != nil {
+		// the violations of proxied analyzers are recorded already
+		tEntry.Response.Diagnostics = store.unrecordedDiagnostics("", tEntry.Response.Diagnostics)
 	}
+	recordAnalyzeStack(ctx, store, &tEntry.Response)
 }
 
 func handleAnalyze(ctx context.Context, evt GrpcEntry, store *ResourceStore) {
@@ -146,11 +380,47 @@ func handleAnalyze(ctx context.Context, evt GrpcEntry, store *ResourceStore) {
 		debug.LogError(ctx, "Error unmarshalling analyze entry: %v", err)
 		return
 	}
-	if tEntry.Response.Diagnostics == nil {
-		debug.Debug.Log(ctx, "No diagnostics found in analyze response for URN", "URN", tEntry.Request.Urn)
+	// the violations of proxied analyzers are recorded already, and so is
+	// the progress if no other analyzer reported any
+	tEntry.Response.Diagnostics = store.unrecordedDiagnostics(tEntry.Request.Urn, tEntry.Response.Diagnostics)
+	if len(tEntry.Response.Diagnostics) == 0 && store.analyzeRecorded(tEntry.Request.Urn) {
+		return
+	}
+	recordAnalyze(ctx, store, &tEntry.Request, &tEntry.Response)
+}
+
+// recordAnalyzeStack records the policy violations reported by an
+// AnalyzeStack call.
+func recordAnalyzeStack(ctx context.Context, store *ResourceStore, response *rpc.AnalyzeResponse) {
+	if response.Diagnostics == nil {
+		debug.Debug.Log(ctx, "No diagnostics found in analyze stack response for Stack")
+		return
+	}
+	for _, d := range response.Diagnostics {
+		store.addDiagnostic(d)
+	}
+}
+
+// recordAnalyze records the policy violations of a resource reported by an
+// Analyze call.
+func recordAnalyze(ctx context.Context, store *ResourceStore, request *rpc.AnalyzeRequest, response *rpc.AnalyzeResponse) {
+	if response.Diagnostics == nil {
+		debug.Debug.Log(ctx, "No diagnostics found in analyze response for URN", "URN", request.Urn)
+	}
+	store.analyzeResource(request.Urn, response.Diagnostics)
+}
+
+func handleGetAnalyzerInfo(ctx context.Context, evt GrpcEntry, store *ResourceStore) {
+	tEntry, err := unmarshalTypedEntry[emptypb.Empty, rpc.AnalyzerInfo](evt.GrpcLogEntry)
+	if err != nil {
+		debug.LogError(ctx, "Error unmarshalling analyzer info entry", err)
+		return
+	}
+	if store.analyzerRecorded(&tEntry.Response) {
+		// recorded by the analyzer proxy
 		return
 	}
-	store.getOrCreateResourceInfo(tEntry.Request.Urn).SetDiagnostics(tEntry.Response.Diagnostics)
+	store.addAnalyzer(&tEntry.Response)
 }
 
 func setupLogTailing(command string, events chan<- GrpcEntry) (*fileWatcher, error) {
@@ -169,13 +439,73 @@ func PolicyPacks(packs []string) optpreview.Option {
 	})
 }
 
+// PolicyPackConfigs sets the paths of the JSON config files of the policy
+// packs, one for each pack passed to PolicyPacks. Packs with an empty path
+// use their default config.
+func PolicyPackConfigs(configs []string) optpreview.Option {
+	return policyPackConfigsOption(configs)
+}
+
+// policyPackConfigsOption is resolved by the runner, see
+// withPolicyPackConfigs.
+type policyPackConfigsOption []string
+
+// ApplyOption implements optpreview.Option. The option is handled by the
+// runner rather than the preview.
+func (policyPackConfigsOption) ApplyOption(*optpreview.Options) {}
+
+// withPolicyPackConfigs replaces a PolicyPackConfigs option with an option
+// setting the paths of the configs. The CLI requires a config file for every
+// pack if any pack has one, so an empty config, which leaves the default
+// config of a pack as is, is written to a private directory for the packs
+// without one. The returned func removes it.
+func withPolicyPackConfigs(opts []optpreview.Option) ([]optpreview.Option, func(), error) {
+	var dir string
+	cleanup := func() {
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+	}
+	resolved := make([]optpreview.Option, 0, len(opts))
+	for _, opt := range opts {
+		configs, ok := opt.(policyPackConfigsOption)
+		if !ok {
+			resolved = append(resolved, opt)
+			continue
+		}
+		var paths []string
+		for _, config := range configs {
+			if config == "" {
+				if dir == "" {
+					var err error
+					if dir, err = os.MkdirTemp("", "pulumilsp-policy-config-"); err != nil {
+						return nil, nil, fmt.Errorf("error creating policy config dir: %w", err)
+					}
+					if err := os.WriteFile(filepath.Join(dir, "empty.json"), []byte("{}"), 0o600); err != nil {
+						cleanup()
+						return nil, nil, fmt.Errorf("error writing empty policy pack config: %w", err)
+					}
+				}
+				config = filepath.Join(dir, "empty.json")
+			}
+			paths = append(paths, config)
+		}
+		resolved = append(resolved, optionFunc(func(opts *optpreview.Options) {
+			opts.PolicyPackConfigs = paths
+		}))
+	}
+	return resolved, cleanup, nil
+}
+
 func (o optionFunc) ApplyOption(opts *optpreview.Options) {
 	o(opts)
 }
 
 type GrpcEntry struct {
 	grpclog.GrpcLogEntry
-	Error error
+	// Errors are the errors returned by the call, if it failed.
+	Errors []string `json:"errors,omitempty"`
+	Error  error    `json:"-"`
 }
 
 type fileWatcher struct {
@@ -204,7 +534,7 @@ func watchFile(path string, receivers []chan<- GrpcEntry) (*fileWatcher, error)
 				}
 				continue
 			}
-			var e grpclog.GrpcLogEntry
+			var e GrpcEntry
 			err = json.Unmarshal([]byte(line.Text), &e)
 			if err != nil {
 				for _, r := range receivers {
@@ -213,7 +543,7 @@ func watchFile(path string, receivers []chan<- GrpcEntry) (*fileWatcher, error)
 				continue
 			}
 			for _, r := range receivers {
-				r <- GrpcEntry{GrpcLogEntry: e}
+				r <- e
 			}
 		}
 		for _, r := range receivers {
@@ -253,9 +583,8 @@ func (fw *fileWatcher) Close() {
 	//nolint:errcheck
 	fw.tail.StopAtEOF()
 	<-fw.done
-	// logDir := filepath.Dir(fw.tail.Filename)
 	fw.tail.Cleanup()
-	// os.RemoveAll(logDir)
+	os.RemoveAll(filepath.Dir(fw.tail.Filename))
 
 	// set to nil so we can safely close again in defer
 	fw.tail = nil
@@ -281,3 +610,7 @@ func unmarshalTypedEntry[TRequest, TResponse any](entry grpclog.GrpcLogEntry) (*
 	}
 	return &typedEntry, nil
 }
+
+func unmarshalMessage(raw json.RawMessage, msg protoreflect.ProtoMessage) error {
+	return jsonpb.Unmarshal(raw, msg)
+}
@@ -0,0 +1,37 @@
+package pulumicommand
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithPolicyPackConfigs(t *testing.T) {
+	opts, cleanup, err := withPolicyPackConfigs([]optpreview.Option{
+		PolicyPacks([]string{"/packs/aws", "/packs/tags"}),
+		PolicyPackConfigs([]string{"/packs/aws.json", ""}),
+	})
+	require.NoError(t, err)
+	var applied optpreview.Options
+	for _, opt := range opts {
+		opt.ApplyOption(&applied)
+	}
+	require.Equal(t, []string{"/packs/aws", "/packs/tags"}, applied.PolicyPacks)
+	require.Len(t, applied.PolicyPackConfigs, 2)
+	require.Equal(t, "/packs/aws.json", applied.PolicyPackConfigs[0])
+
+	empty := applied.PolicyPackConfigs[1]
+	content, err := os.ReadFile(empty)
+	require.NoError(t, err)
+	require.Equal(t, "{}", string(content))
+	info, err := os.Stat(filepath.Dir(empty))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o700), info.Mode().Perm(), "the config is private")
+
+	cleanup()
+	_, err = os.Stat(empty)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
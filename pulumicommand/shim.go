@@ -0,0 +1,89 @@
+package pulumicommand
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// AnalyzerShimCommand is the command of the pulumilsp executable that the
+// analyzer shims of a preview run, see RunAnalyzerShim.
+const AnalyzerShimCommand = "analyzer-shim"
+
+// RunAnalyzerShim runs an analyzer plugin on behalf of the CLI and registers
+// it with the analyzer proxy of a preview. args are the address of the proxy,
+// the path of the analyzer, and the arguments the CLI ran the shim with.
+//
+// The CLI connects to the port a plugin prints on startup, so the shim
+// prints the port of the proxy instead of the port of the analyzer. If the
+// analyzer can't be registered, it prints the port of the analyzer, so the
+// preview runs as if there was no shim. The CLI runs the analyzer of a policy
+// pack in the directory of the pack, so the shim registers the analyzer with
+// its working directory to tell the proxy which pack it belongs to.
+func RunAnalyzerShim(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: %s <proxy address> <analyzer> [args...]", AnalyzerShimCommand)
+	}
+	proxyAddr, analyzer := args[0], args[1]
+
+	cmd := exec.Command(analyzer, args[2:]...)
+	cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	out := bufio.NewReader(stdout)
+	port, err := out.ReadString('\n')
+	if err != nil {
+		// the analyzer failed to start, its errors are on stderr
+		return cmd.Wait()
+	}
+	port = strings.TrimSpace(port)
+	dir, _ := os.Getwd()
+	if proxyPort, err := registerAnalyzer(proxyAddr, port, dir); err == nil {
+		port = proxyPort
+	}
+	fmt.Println(port)
+	_, _ = io.Copy(os.Stdout, out)
+	return cmd.Wait()
+}
+
+// registerAnalyzer registers the analyzer listening on port with the proxy
+// at proxyAddr, and returns the port of the proxy. dir is the directory of
+// the policy pack of the analyzer, or empty if it isn't known.
+func registerAnalyzer(proxyAddr, port, dir string) (string, error) {
+	conn, err := net.DialTimeout("tcp", proxyAddr, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n%s\n", port, dir); err != nil {
+		return "", err
+	}
+	proxyPort, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(proxyPort), nil
+}
@@ -7,9 +7,20 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/corymhall/pulumilsp/xcontext"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 )
 
+// cancelRequestMethod is the notification LSP clients send to cancel a call
+// they made.
+const cancelRequestMethod = "$/cancelRequest"
+
+// cancelParams are the params of a $/cancelRequest notification.
+type cancelParams struct {
+	// The id of the call to cancel.
+	ID *ID `json:"id"`
+}
+
 // Conn is the common interface to jsonrpc servers.
 // Conn is bidirectional; it does not have a designated server or client end.
 // It manages the jsonrpc2 protocol, connecting responses back to their calls.
@@ -33,19 +44,46 @@ type Conn interface {
 }
 
 type conn struct {
-	seq       int64 // must only be accessed using atomic operations
-	stream    Stream
-	pendingMu sync.Mutex // protects the pending map
-	pending   map[ID]chan *Response
-	done      chan struct{}
+	seq        int64 // must only be accessed using atomic operations
+	stream     Stream
+	writeMu    sync.Mutex // serializes writes to the stream
+	pendingMu  sync.Mutex // protects the pending map
+	pending    map[ID]chan *Response
+	handlingMu sync.Mutex                // protects the handling map
+	handling   map[ID]context.CancelFunc // cancels the calls being handled
+	done       chan struct{}
+	// concurrent are the methods whose calls are handled concurrently.
+	concurrent map[string]bool
+}
+
+// ConnOption configures a connection created by NewConn.
+type ConnOption func(*conn)
+
+// ConcurrentMethods makes a connection handle the calls of methods
+// concurrently, so that they can be cancelled with $/cancelRequest while
+// they are handled. They must not depend on the order they are handled in,
+// e.g. because they only read state. Calls of other methods are handled in
+// order with the notifications, and a $/cancelRequest for them is only read
+// once they were handled, so it has no effect.
+func ConcurrentMethods(methods ...string) ConnOption {
+	return func(c *conn) {
+		for _, method := range methods {
+			c.concurrent[method] = true
+		}
+	}
 }
 
 // NewConn creates a new connection object around the supplied stream.
-func NewConn(s Stream) Conn {
+func NewConn(s Stream, opts ...ConnOption) Conn {
 	conn := &conn{
-		stream:  s,
-		pending: make(map[ID]chan *Response),
-		done:    make(chan struct{}),
+		stream:     s,
+		pending:    make(map[ID]chan *Response),
+		handling:   make(map[ID]context.CancelFunc),
+		done:       make(chan struct{}),
+		concurrent: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(conn)
 	}
 	return conn
 }
@@ -103,18 +141,25 @@ func (c *conn) Call(ctx context.Context, method string, params, result any) (_ I
 		return id, ctx.Err()
 	}
 }
-func (c *conn) replier(req Request) Replier {
+
+// replier returns the Replier of a request handled with reqCtx. Errors of
+// calls that were cancelled are replied with ErrRequestCancelled.
+func (c *conn) replier(reqCtx context.Context, req Request) Replier {
 	return func(ctx context.Context, result any, err error) error {
 		call, ok := req.(*Call)
 		if !ok {
 			// request was a notify, no need to respond
 			return nil
 		}
+		if err != nil && reqCtx.Err() != nil {
+			err = ErrRequestCancelled
+		}
 		response, err := NewResponse(call.id, result, err)
 		if err != nil {
 			return err
 		}
-		_, err = c.write(ctx, response)
+		// a cancelled call is still replied to
+		_, err = c.write(xcontext.Detach(ctx), response)
 		if err != nil {
 			return err
 		}
@@ -123,6 +168,8 @@ func (c *conn) replier(req Request) Replier {
 }
 
 func (c *conn) write(ctx context.Context, msg Message) (int64, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
 	return c.stream.Write(ctx, msg)
 }
 
@@ -137,10 +184,40 @@ func (c *conn) Run(ctx context.Context, handler Handler) {
 			return
 		}
 		switch msg := msg.(type) {
-		case Request:
-			if err := handler(ctx, c.replier(msg), msg); err != nil {
+		case *Notification:
+			if msg.method == cancelRequestMethod {
+				c.cancelCall(msg)
+				continue
+			}
+			// notifications are handled in order, e.g. the changes of a
+			// document
+			if err := handler(ctx, c.replier(ctx, msg), msg); err != nil {
 				// delivery failed, not much we can do
 			}
+		case *Call:
+			// every call has its own context, which is cancelled once it
+			// was handled
+			callCtx, cancel := context.WithCancel(ctx)
+			c.handlingMu.Lock()
+			c.handling[msg.id] = cancel
+			c.handlingMu.Unlock()
+			handle := func() {
+				defer func() {
+					c.handlingMu.Lock()
+					delete(c.handling, msg.id)
+					c.handlingMu.Unlock()
+					cancel()
+				}()
+				if err := handler(callCtx, c.replier(callCtx, msg), msg); err != nil {
+					// delivery failed, not much we can do
+				}
+			}
+			if c.concurrent[msg.method] {
+				go handle()
+			} else {
+				// handled in order, e.g. calls that change the settings
+				handle()
+			}
 		case *Response:
 			// If method is not set, this should be a response, in which case we must
 			// have an id to send the response back to the caller.
@@ -154,6 +231,21 @@ func (c *conn) Run(ctx context.Context, handler Handler) {
 	}
 }
 
+// cancelCall cancels the context of the call a $/cancelRequest notification
+// refers to, if it is still being handled.
+func (c *conn) cancelCall(notify *Notification) {
+	var params cancelParams
+	if err := json.Unmarshal(notify.params, &params); err != nil || params.ID == nil {
+		return
+	}
+	c.handlingMu.Lock()
+	cancel, ok := c.handling[*params.ID]
+	c.handlingMu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
 func (c *conn) Done() <-chan struct{} {
 	return c.done
 }
@@ -0,0 +1,99 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// connPair returns the client end of a connection to a server handling
+// requests with handler.
+func connPair(ctx context.Context, handler Handler, opts ...ConnOption) Conn {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	server := NewConn(NewHeaderStream(serverIn, serverOut), opts...)
+	client := NewConn(NewHeaderStream(clientIn, clientOut))
+	go server.Run(ctx, handler)
+	go client.Run(ctx, MethodNotFound)
+	return client
+}
+
+func TestCancelRequest(t *testing.T) {
+	ctx := context.Background()
+	started := make(chan struct{})
+	client := connPair(ctx, func(ctx context.Context, reply Replier, req Request) error {
+		switch req.Method() {
+		case "slow":
+			close(started)
+			<-ctx.Done()
+			return reply(ctx, nil, ctx.Err())
+		case "fail":
+			return reply(ctx, nil, errors.New("boom"))
+		default:
+			return reply(ctx, req.Method(), nil)
+		}
+	}, ConcurrentMethods("slow"))
+
+	slow := make(chan error)
+	go func() {
+		_, err := client.Call(ctx, "slow", nil, nil)
+		slow <- err
+	}()
+	<-started
+
+	// other calls are handled while the slow call runs
+	var result string
+	_, err := client.Call(ctx, "fast", nil, &result)
+	require.NoError(t, err)
+	require.Equal(t, "fast", result)
+	_, err = client.Call(ctx, "fail", nil, nil)
+	require.Equal(t, NewError(codeUnknown, "boom"), err)
+
+	require.NoError(t, client.Notify(ctx, "$/cancelRequest", map[string]any{"id": 1}))
+	err = <-slow
+	var rpcErr *Error
+	require.ErrorAs(t, err, &rpcErr)
+	require.Equal(t, CodeRequestCancelled, rpcErr.Code)
+}
+
+func TestOrderedCalls(t *testing.T) {
+	ctx := context.Background()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	notified := make(chan struct{})
+	client := connPair(ctx, func(ctx context.Context, reply Replier, req Request) error {
+		switch req.Method() {
+		case "ordered":
+			close(started)
+			<-release
+			return reply(ctx, nil, nil)
+		default:
+			close(notified)
+			return reply(ctx, nil, nil)
+		}
+	})
+
+	called := make(chan error)
+	go func() {
+		_, err := client.Call(ctx, "ordered", nil, nil)
+		called <- err
+	}()
+	<-started
+
+	// the notification is handled once the call was handled
+	go func() {
+		_ = client.Notify(ctx, "notify", nil)
+	}()
+	select {
+	case <-notified:
+		t.Fatal("notification handled while the call is handled")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	require.NoError(t, <-called)
+	<-notified
+}
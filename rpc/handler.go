@@ -27,6 +27,46 @@ var (
 	ErrServerOverloaded = "JSON RPC overloaded"
 )
 
+const (
+	// CodeRequestCancelled is the error code of the reply to a call that was
+	// cancelled with a $/cancelRequest notification. It is defined by LSP
+	// rather than JSON-RPC.
+	CodeRequestCancelled int64 = -32800
+
+	// codeUnknown is the error code of replies with errors that aren't an
+	// *Error.
+	codeUnknown int64 = -32001
+)
+
+// ErrRequestCancelled is the error of the reply to a cancelled call.
+var ErrRequestCancelled = NewError(CodeRequestCancelled, "JSON RPC cancelled")
+
+// Error is an error with a JSON-RPC error code. It is the error of a reply
+// as it is sent on the wire.
+type Error struct {
+	Code    int64  `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewError returns an error with the given code and message.
+func NewError(code int64, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// toError returns the error err is sent as, keeping the code of an *Error it
+// wraps.
+func toError(err error) *Error {
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return NewError(rpcErr.Code, err.Error())
+	}
+	return NewError(codeUnknown, err.Error())
+}
+
 // Handler is invoked to handle incoming requests.
 // The Replier sends a reply to the request and must be called exactly once.
 type Handler func(ctx context.Context, reply Replier, req Request) error
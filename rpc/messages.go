@@ -136,8 +136,10 @@ func (msg *Response) Err() error              { return msg.err }
 func (msg *Response) isRPCMessage()           {}
 
 func (r *Response) MarshalJSON() ([]byte, error) {
-	msg := &wireResponse{Error: r.err, ID: &r.id}
-	if msg.Error == nil {
+	msg := &wireResponse{ID: &r.id}
+	if r.err != nil {
+		msg.Error = toError(r.err)
+	} else {
 		msg.Result = &r.result
 	}
 	data, err := json.Marshal(msg)
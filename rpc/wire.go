@@ -35,7 +35,7 @@ type wireResponse struct {
 	// Result is the response value, and is required on success.
 	Result *json.RawMessage `json:"result,omitempty"`
 	// Error is a structured error response if the call fails.
-	Error error `json:"error,omitempty"`
+	Error *Error `json:"error,omitempty"`
 	// ID must be set and is the identifier of the Request this is a response to.
 	ID *ID `json:"id,omitempty"`
 }
@@ -48,7 +48,7 @@ type wireCombined struct {
 	Method     string           `json:"method"`
 	Params     *json.RawMessage `json:"params,omitempty"`
 	Result     *json.RawMessage `json:"result,omitempty"`
-	Error      error            `json:"error,omitempty"`
+	Error      *Error           `json:"error,omitempty"`
 }
 
 // wireVersionTag is a special 0 sized struct that encodes as the jsonrpc version
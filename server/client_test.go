@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/corymhall/pulumilsp/lsp"
+)
+
+// recordingClient is an lsp.Client that records what the server sends to
+// it. It is safe for concurrent use, since the server reports from the
+// goroutines of its previews.
+type recordingClient struct {
+	mu        sync.Mutex
+	published map[lsp.DocumentURI][][]lsp.Diagnostic
+	begun     []*lsp.WorkDoneProgressBeginParams
+	reports   []lsp.WorkDoneProgressReportValue
+	ended     []*lsp.WorkDoneProgressEndParams
+	messages  []*lsp.ShowMessageParams
+	logs      []*lsp.LogMessageParams
+}
+
+var _ lsp.Client = (*recordingClient)(nil)
+
+func newRecordingClient() *recordingClient {
+	return &recordingClient{published: make(map[lsp.DocumentURI][][]lsp.Diagnostic)}
+}
+
+func (c *recordingClient) PublishDiagnostics(_ context.Context, params *lsp.PublishDiagnosticsParams) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published[params.URI] = append(c.published[params.URI], params.Diagnostics)
+	return nil
+}
+
+func (c *recordingClient) WorkDoneProgressCreate(context.Context, *lsp.WorkDoneProgressCreateParams) error {
+	return nil
+}
+
+func (c *recordingClient) ProgressBegin(_ context.Context, params *lsp.WorkDoneProgressBeginParams) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.begun = append(c.begun, params)
+	return nil
+}
+
+func (c *recordingClient) ProgressReport(_ context.Context, params *lsp.WorkDoneProgressReportParams) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reports = append(c.reports, params.Value)
+	return nil
+}
+
+func (c *recordingClient) ProgressEnd(_ context.Context, params *lsp.WorkDoneProgressEndParams) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ended = append(c.ended, params)
+	return nil
+}
+
+func (c *recordingClient) ShowMessage(_ context.Context, params *lsp.ShowMessageParams) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, params)
+	return nil
+}
+
+func (c *recordingClient) LogMessage(_ context.Context, params *lsp.LogMessageParams) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logs = append(c.logs, params)
+	return nil
+}
+
+// Configuration returns no settings, so the server uses its defaults.
+func (c *recordingClient) Configuration(context.Context, *lsp.ParamConfiguration) ([]lsp.LSPAny, error) {
+	return nil, nil
+}
+
+// publications returns the diagnostics published for uri, in order.
+func (c *recordingClient) publications(uri lsp.DocumentURI) [][]lsp.Diagnostic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.published[uri]
+}
+
+// lastPublished returns the diagnostics last published for each file.
+func (c *recordingClient) lastPublished() map[lsp.DocumentURI][]lsp.Diagnostic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := make(map[lsp.DocumentURI][]lsp.Diagnostic, len(c.published))
+	for uri, publications := range c.published {
+		last[uri] = publications[len(publications)-1]
+	}
+	return last
+}
+
+func (c *recordingClient) lastReport() lsp.WorkDoneProgressReportValue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.reports) == 0 {
+		return lsp.WorkDoneProgressReportValue{}
+	}
+	return c.reports[len(c.reports)-1]
+}
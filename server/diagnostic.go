@@ -30,8 +30,7 @@ type Diagnostic struct {
 	Data    *json.RawMessage
 
 	// Tags    []protocol.DiagnosticTag
-	// Related []protocol.DiagnosticRelatedInformation
-
+	Related []lsp.DiagnosticRelatedInformation
 }
 
 // Hash computes a hash to identify the diagnostic.
@@ -41,14 +40,14 @@ func (d *Diagnostic) Hash() file.Hash {
 	// for _, t := range d.Tags {
 	// 	fmt.Fprintf(h, "tag: %s\n", t)
 	// }
-	// for _, r := range d.Related {
-	// 	fmt.Fprintf(h, "related: %s %s %s\n", r.Location.URI, r.Message, r.Location.Range)
-	// }
+	for _, r := range d.Related {
+		fmt.Fprintf(h, "related: %s %s %v\n", r.Location.URI, r.Message, r.Location.Range)
+	}
 	fmt.Fprintf(h, "code: %s\n", d.Code)
 	fmt.Fprintf(h, "codeHref: %s\n", d.CodeHref)
 	fmt.Fprintf(h, "message: %s\n", d.Message)
-	fmt.Fprintf(h, "range: %s\n", d.Range)
-	fmt.Fprintf(h, "severity: %s\n", d.Severity)
+	fmt.Fprintf(h, "range: %v\n", d.Range)
+	fmt.Fprintf(h, "severity: %v\n", d.Severity)
 	fmt.Fprintf(h, "source: %s\n", d.Source)
 	if d.Data != nil {
 		fmt.Fprintf(h, "fixes: %s\n", *d.Data)
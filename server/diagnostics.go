@@ -5,12 +5,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/corymhall/pulumilsp/debug"
+	"github.com/corymhall/pulumilsp/file"
 	"github.com/corymhall/pulumilsp/lsp"
 	"github.com/corymhall/pulumilsp/parser"
+	"github.com/corymhall/pulumilsp/pulumicommand"
+	"github.com/corymhall/pulumilsp/xcontext"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
 )
@@ -20,9 +26,14 @@ type (
 )
 
 // fileDiagnostics holds the current state of published diagnostics for a file.
+// A file can have diagnostics from several views, e.g. a library shared by
+// several projects, in which case the diagnostics of all views are published.
+// While a preview runs, its partial diagnostics are published instead of those
+// of the last completed preview of the view.
 type fileDiagnostics struct {
-	mustPublish    bool // if set, publish diagnostics even if they haven't changed
-	viewDiagnostic *viewDiagnostics
+	mustPublish bool // if set, publish diagnostics even if they haven't changed
+	byView      map[*View]*viewDiagnostics
+	partial     map[*View]*viewDiagnostics
 }
 
 // viewDiagnostics holds a set of file diagnostics computed from a given View.
@@ -42,42 +53,72 @@ func (s *server) mustPublishDiagnostics(uri lsp.DocumentURI) {
 	s.diagnostics[uri].mustPublish = true
 }
 
-func (s *server) diagnoseSnapshot(ctx context.Context, snapshot *Snapshot, changedURIs []lsp.DocumentURI, delay time.Duration) {
-	diagnostics, err := s.diagnose(ctx, snapshot)
+func (s *server) diagnoseSnapshot(ctx context.Context, snapshot *Snapshot, changedURIs []lsp.DocumentURI, cause ModificationSource, delay time.Duration, work *WorkDone) {
+	diagnostics, err := s.diagnose(ctx, snapshot, cause, work)
 	if err != nil {
 		debug.LogError(ctx, "diagnoseSnapshot", err)
+		// the preview failed or was cancelled, so go back to the diagnostics
+		// of the last completed preview
+		s.clearPartialDiagnostics(xcontext.Detach(ctx), snapshot)
 		return
 	}
 
 	s.updateDiagnostics(ctx, snapshot, diagnostics)
 }
 
-func (s *server) diagnoseChangedView(ctx context.Context, modID uint64, lastChange []lsp.DocumentURI, cause ModificationSource) {
+func (s *server) diagnoseChangedView(ctx context.Context, view *View, modID uint64, lastChange []lsp.DocumentURI, cause ModificationSource) {
 	ctx, done := debug.Start(ctx, "diagnoseChangedView")
 	defer done()
 
-	snapshot, release, err := s.view.Snapshot()
+	if cause == FromDidChange {
+		// Wait for the user to stop typing. Every change cancels the
+		// diagnostics of the previous one, so only the last change of a burst
+		// is previewed.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(view.Settings().previewDelay()):
+		}
+	}
+
+	snapshot, release, err := view.Snapshot()
 	if err != nil {
 		debug.LogError(ctx, "error getting view", err)
 		return
 	}
 	defer release()
+	// e.g. after selecting another stack, the snapshot needs a new runner
+	snapshot.AwaitInitialized(ctx)
 
-	if cause != FromDidSave {
-		// only update on save
+	// the preview can be cancelled from the editor, which interrupts the
+	// pulumi process
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	work := s.progress.Start(ctx, "Pulumi", "Running preview...", nil, cancel)
+	s.diagnoseSnapshot(ctx, snapshot, lastChange, cause, 0 /* delay */, work)
+	if work.isCancelled() {
+		work.End(ctx, "Preview cancelled.")
 		return
 	}
-
-	work := s.progress.Start(ctx, "Pulumi", "Running preview...", nil, nil)
-	s.diagnoseSnapshot(ctx, snapshot, lastChange, 0 /* delay */)
 	work.End(ctx, "Done.")
 }
 
-func (s *server) publishFileDiagnostics(ctx context.Context, uri lsp.DocumentURI, f *fileDiagnostics) error {
+// publishFileDiagnostics publishes the diagnostics of all views for a file,
+// for the given version of the file.
+func (s *server) publishFileDiagnostics(ctx context.Context, uri lsp.DocumentURI, version int32, f *fileDiagnostics) error {
+	var diagnostics []*Diagnostic
+	for view, vd := range f.byView {
+		if _, ok := f.partial[view]; !ok {
+			diagnostics = append(diagnostics, vd.diagnostics...)
+		}
+	}
+	for _, vd := range f.partial {
+		diagnostics = append(diagnostics, vd.diagnostics...)
+	}
 	if err := s.client.PublishDiagnostics(ctx, &lsp.PublishDiagnosticsParams{
-		Diagnostics: toProtocolDiagnostics(f.viewDiagnostic.diagnostics),
+		Diagnostics: toProtocolDiagnostics(diagnostics),
 		URI:         uri,
-		Version:     f.viewDiagnostic.version,
+		Version:     version,
 	}); err != nil {
 		debug.LogError(ctx, "error publishing diagnostics", err)
 		return err
@@ -85,6 +126,27 @@ func (s *server) publishFileDiagnostics(ctx context.Context, uri lsp.DocumentURI
 	return nil
 }
 
+// clearViewDiagnostics drops the diagnostics of a view that was shut down,
+// and republishes the diagnostics of the files it had diagnostics for.
+func (s *server) clearViewDiagnostics(ctx context.Context, view *View) {
+	s.diagnosticsMu.Lock()
+	defer s.diagnosticsMu.Unlock()
+	for uri, f := range s.diagnostics {
+		vd := f.byView[view]
+		if partial, ok := f.partial[view]; ok {
+			vd = partial
+		}
+		if vd == nil {
+			continue
+		}
+		delete(f.byView, view)
+		delete(f.partial, view)
+		if err := s.publishFileDiagnostics(ctx, uri, vd.version, f); err != nil {
+			debug.LogError(ctx, "error clearing diagnostics", err)
+		}
+	}
+}
+
 func toProtocolDiagnostics(diags []*Diagnostic) []lsp.Diagnostic {
 	reports := []lsp.Diagnostic{}
 	for _, diag := range diags {
@@ -94,6 +156,8 @@ func toProtocolDiagnostics(diags []*Diagnostic) []lsp.Diagnostic {
 			Severity: diag.Severity,
 			Source:   string(diag.Source),
 			Data:     diag.Data,
+
+			RelatedInformation: diag.Related,
 		}
 		reports = append(reports, pdiag)
 	}
@@ -112,33 +176,15 @@ func (s *server) updateDiagnostics(ctx context.Context, snapshot *Snapshot, diag
 		debug.LogError(ctx, "context error while updating diagnostics for snapshot", ctx.Err())
 		return
 	}
-
-	// updateAndPublish updates diagnostics for a file.
-	// Because we only update diagnostics on save, we always overwrite existing
-	// diagnostics.
-	updateAndPublish := func(uri lsp.DocumentURI, f *fileDiagnostics, diags []*Diagnostic) error {
-		fh, err := snapshot.ReadFile(ctx, uri)
-		if err != nil {
-			return err
-		}
-		f.viewDiagnostic = &viewDiagnostics{
-			snapshot:    snapshot.SequenceID(),
-			version:     fh.Version(),
-			diagnostics: diags,
-		}
-
-		return s.publishFileDiagnostics(ctx, uri, f)
+	// the view may have been removed while it was diagnosed
+	if snapshot.view.isShutdown() {
+		return
 	}
 
 	seen := make(map[lsp.DocumentURI]bool)
 	for uri, diags := range diagnostics {
-		f, ok := s.diagnostics[uri]
-		if !ok {
-			f = &fileDiagnostics{}
-			s.diagnostics[uri] = f
-		}
 		seen[uri] = true
-		if err := updateAndPublish(uri, f, diags); err != nil {
+		if err := s.updateFileDiagnosticsLocked(ctx, snapshot, uri, diags); err != nil {
 			debug.LogError(ctx, "context error while updating diagnostics", err)
 			if ctx.Err() != nil {
 				return
@@ -146,10 +192,11 @@ func (s *server) updateDiagnostics(ctx context.Context, snapshot *Snapshot, diag
 		}
 	}
 
-	// clean up files that have no diagnostics
+	// clean up files that have no diagnostics, leaving those of other
+	// projects alone
 	for uri, f := range s.diagnostics {
-		if !seen[uri] {
-			if err := updateAndPublish(uri, f, nil); err != nil {
+		if !seen[uri] && (f.byView[snapshot.view] != nil || f.partial[snapshot.view] != nil || s.viewOf(uri) == snapshot.view) {
+			if err := s.updateFileDiagnosticsLocked(ctx, snapshot, uri, nil); err != nil {
 				debug.LogError(ctx, "context error while updating diagnostics", err)
 				if ctx.Err() != nil {
 					return
@@ -159,51 +206,131 @@ func (s *server) updateDiagnostics(ctx context.Context, snapshot *Snapshot, diag
 	}
 }
 
-func (s *server) diagnose(ctx context.Context, snapshot *Snapshot) (diagMap, error) {
+// updateFileDiagnosticsLocked replaces the diagnostics of the view of
+// snapshot for a file and publishes them. Because every preview covers the
+// whole program, existing diagnostics are always overwritten, including the
+// partial diagnostics of the preview.
+func (s *server) updateFileDiagnosticsLocked(ctx context.Context, snapshot *Snapshot, uri lsp.DocumentURI, diags []*Diagnostic) error {
+	fh, err := snapshot.ReadFile(ctx, uri)
+	if err != nil {
+		return err
+	}
+	f, ok := s.diagnostics[uri]
+	if !ok {
+		f = &fileDiagnostics{}
+		s.diagnostics[uri] = f
+	}
+	if f.byView == nil {
+		f.byView = make(map[*View]*viewDiagnostics)
+	}
+	f.byView[snapshot.view] = &viewDiagnostics{
+		snapshot:    snapshot.SequenceID(),
+		version:     fh.Version(),
+		diagnostics: diags,
+	}
+	delete(f.partial, snapshot.view)
+	return s.publishFileDiagnostics(ctx, uri, fh.Version(), f)
+}
+
+// diagnose runs a preview of snapshot and returns the resulting diagnostics.
+// Changes that were not saved are previewed in a shadow copy of the project.
+// Policy violations are published as they are reported while the preview
+// runs, and its progress is reported to work, relative to the size of the
+// previous preview.
+func (s *server) diagnose(ctx context.Context, snapshot *Snapshot, cause ModificationSource, work *WorkDone) (diagMap, error) {
 	ctx, done := debug.Start(ctx, "server.diagnose")
 	defer done()
 	// wait for a free diagnostics slot
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
-	case s.diagnosticsSema <- struct{}{}:
+	case snapshot.view.diagnosticsSema <- unit{}:
 	}
 
 	// defer release the semaphore
 	defer func() {
-		<-s.diagnosticsSema
+		<-snapshot.view.diagnosticsSema
 	}()
 
-	diagnostics := make(diagMap)
-
 	initialErr := snapshot.InitializationError()
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
-	s.updateCriticalErrorStatus(ctx, snapshot, initialErr)
+	s.updateCriticalErrorStatus(ctx, snapshot.view, initialErr)
 	runner := snapshot.PulumiCmdRunner()
 	if runner == nil {
 		return nil, fmt.Errorf("no runner")
 	}
 
-	resources, err := runner.Run(ctx)
-	// TODO: we need to differentiate between critical errors
-	// (i.e. errors that prevent any results) and errors on individual resources
-	if err != nil {
+	settings := snapshot.settings
+	root := snapshot.view.root.Path()
+	opts := []optpreview.Option{
+		pulumicommand.PolicyPacks(settings.policyPackPaths(root)),
+		pulumicommand.PolicyPackConfigs(settings.policyPackConfigPaths(root)),
+	}
+	var result *pulumicommand.Result
+	var err error
+	var partial *partialDiagnostics
+	if cause == FromDidChange {
+		partial = s.startPartialDiagnostics(ctx, snapshot, work, snapshot.view.shadow.projectURI)
+		result, err = snapshot.view.shadow.run(ctx, runner, snapshot.Overlays(), append(opts, partial.option())...)
+	} else {
+		partial = s.startPartialDiagnostics(ctx, snapshot, work, nil)
+		result, err = runner.Run(ctx, append(opts, partial.option())...)
+	}
+	partial.stop()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err == nil {
+		snapshot.view.setLastPreviewSize(partial.registeredResources())
+	}
+	if err != nil && result == nil {
 		debug.LogError(ctx, "error running Run", err)
-		s.updateCriticalErrorStatus(ctx, snapshot, &InitializationError{
+		s.updateCriticalErrorStatus(ctx, snapshot.view, &InitializationError{
 			MainError: err,
 		})
 		return nil, err
 	}
 
 	fileCaptures := make(map[lsp.DocumentURI][]parser.CaptureInfo)
+	diagnostics := s.policyDiagnostics(ctx, settings, fileCaptures, result.Resources)
+	for uri, diags := range policyConfigDiagnostics(ctx, snapshot, result.Analyzers, result.PolicyPacks) {
+		diagnostics[uri] = append(diagnostics[uri], diags...)
+	}
+	for _, engineErr := range result.Errors {
+		d := s.engineErrorDiagnostic(ctx, snapshot, fileCaptures, engineErr)
+		diagnostics[d.URI] = append(diagnostics[d.URI], d)
+	}
+
+	// errors that prevent any results are critical, errors in the program or
+	// on individual resources are reported as diagnostics
+	if err != nil && len(diagnostics) == 0 {
+		debug.LogError(ctx, "error running Run", err)
+		s.updateCriticalErrorStatus(ctx, snapshot.view, &InitializationError{
+			MainError: err,
+		})
+		return nil, err
+	}
+
+	return diagnostics, nil
+}
+
+// policyDiagnostics returns the diagnostics of the policy violations of
+// resources. Violations of resources declared at the same place are grouped
+// into a single diagnostic.
+func (s *server) policyDiagnostics(
+	ctx context.Context,
+	settings *Settings,
+	fileCaptures map[lsp.DocumentURI][]parser.CaptureInfo,
+	resources map[string]*pulumicommand.ResourceInfo,
+) diagMap {
+	diagnostics := make(diagMap)
+	groups := make(map[diagnosticKey]*diagnosticGroup)
 	for urn, info := range resources {
 		_, logger := debug.WithGroup(ctx, "diagnostics")
 		logger = logger.With(
 			"urn", urn,
-			"line", info.SourcePosition.Line,
-			"uri", info.SourcePosition.Uri,
 			"numDiagnostics", len(info.Diagnostics),
 			"resources", len(resources),
 		)
@@ -211,69 +338,189 @@ func (s *server) diagnose(ctx context.Context, snapshot *Snapshot) (diagMap, err
 			logger.DebugContext(ctx, "No diagnostics or source position")
 			continue
 		}
-		uri := lsp.DocumentURI(info.SourcePosition.Uri)
-		if _, ok := fileCaptures[uri]; !ok {
-			captures, err := s.GetCapturesFromURI(ctx, uri)
-			if err != nil {
-				logger.ErrorContext(ctx, "error getting captures from URI", "error", err)
+		logger = logger.With(
+			"line", info.SourcePosition.Line,
+			"uri", info.SourcePosition.Uri,
+		)
+		uri, diagCapture, err := s.locateResource(ctx, fileCaptures, info)
+		if err != nil {
+			logger.DebugContext(ctx, "Unable to locate resource", "error", err)
+			continue
+		}
+		for _, diag := range info.Diagnostics {
+			if !settings.policyEnabled(diag.PolicyName) {
 				continue
 			}
-			fileCaptures[uri] = captures
+			// Resources created in a loop or by a component all report the
+			// same source position, so group their diagnostics by capture.
+			key := diagnosticKey{
+				uri:     uri,
+				start:   captureRange(diagCapture).Start,
+				policy:  diag.PolicyName,
+				message: diag.Message,
+			}
+			group, ok := groups[key]
+			if !ok {
+				data := lsp.CodeActionResolveData{
+					CaptureInfo: *diagCapture,
+					URI:         uri,
+				}
+				rawData, err := json.Marshal(data)
+				if err != nil {
+					slog.ErrorContext(ctx, "error marshalling capture", "error", err)
+					continue
+				}
+				msg := json.RawMessage(rawData)
+				group = &diagnosticGroup{
+					diagnostic: &Diagnostic{
+						Range:    captureRange(diagCapture),
+						Data:     &msg,
+						URI:      uri,
+						Message:  diag.Message,
+						Severity: enforcementLevelToSeverity(diag.EnforcementLevel),
+						Source:   DiagnosticSource(diag.PolicyName),
+					},
+				}
+				groups[key] = group
+				diagnostics[uri] = append(diagnostics[uri], group.diagnostic)
+			}
+			group.instances = append(group.instances, info)
 		}
-		infos := fileCaptures[uri]
-		diags := []*Diagnostic{}
-		for _, diag := range info.Diagnostics {
-			diagCapture := findCaptureWithStartLine(infos, info.SourcePosition.Line-1)
-			data := lsp.CodeActionResolveData{
-				CaptureInfo: *diagCapture,
-				URI:         uri,
+	}
+
+	for _, group := range groups {
+		s.annotateInstances(ctx, fileCaptures, resources, group)
+	}
+	return diagnostics
+}
+
+// diagnosticKey identifies a policy violation reported on a capture.
+type diagnosticKey struct {
+	uri     lsp.DocumentURI
+	start   lsp.Position
+	policy  string
+	message string
+}
+
+// diagnosticGroup is a diagnostic along with every resource that reported it.
+type diagnosticGroup struct {
+	diagnostic *Diagnostic
+	instances  []*pulumicommand.ResourceInfo
+}
+
+// locateResource returns the file and capture that declared the resource.
+// Captures are parsed at most once per file and cached in fileCaptures.
+func (s *server) locateResource(
+	ctx context.Context,
+	fileCaptures map[lsp.DocumentURI][]parser.CaptureInfo,
+	info *pulumicommand.ResourceInfo,
+) (lsp.DocumentURI, *parser.CaptureInfo, error) {
+	if info.SourcePosition == nil {
+		return "", nil, fmt.Errorf("no source position for %s", info.URN)
+	}
+	uri := lsp.DocumentURI(info.SourcePosition.Uri)
+	if file.KindForURI(uri) == file.UnknownKind {
+		return "", nil, fmt.Errorf("no resource parser for %s", uri)
+	}
+	if _, ok := fileCaptures[uri]; !ok {
+		captures, err := s.GetCapturesFromURI(ctx, uri)
+		if err != nil {
+			return "", nil, fmt.Errorf("error getting captures from URI: %w", err)
+		}
+		fileCaptures[uri] = captures
+	}
+	capture := matchCapture(fileCaptures[uri], info.URN, int(info.SourcePosition.Line)-1)
+	if capture == nil {
+		return "", nil, fmt.Errorf("no resource found matching %s", info.URN)
+	}
+	return uri, capture, nil
+}
+
+// annotateInstances records which resources reported a grouped diagnostic.
+// Each instance is added to the related information along with the chain of
+// components it was created by, so it is possible to tell which
+// instantiation violated the policy.
+func (s *server) annotateInstances(
+	ctx context.Context,
+	fileCaptures map[lsp.DocumentURI][]parser.CaptureInfo,
+	resources map[string]*pulumicommand.ResourceInfo,
+	group *diagnosticGroup,
+) {
+	d := group.diagnostic
+	slices.SortFunc(group.instances, func(a, b *pulumicommand.ResourceInfo) int {
+		return strings.Compare(string(a.URN), string(b.URN))
+	})
+	if len(group.instances) > 1 {
+		d.Message = fmt.Sprintf("%s (%d resources)", d.Message, len(group.instances))
+	}
+
+	seen := make(map[string]bool)
+	for _, info := range group.instances {
+		ancestors := componentAncestors(resources, info)
+		if len(group.instances) == 1 && len(ancestors) == 0 {
+			// a single top level resource, there's nothing to disambiguate
+			continue
+		}
+		names := make([]string, 0, len(ancestors))
+		for _, ancestor := range slices.Backward(ancestors) {
+			names = append(names, ancestor.LogicalName())
+		}
+		message := fmt.Sprintf("%s: %s", info.LogicalName(), info.URN)
+		if len(names) > 0 {
+			message = fmt.Sprintf("%s (in %s)", message, strings.Join(names, " > "))
+		}
+		d.Related = append(d.Related, lsp.DiagnosticRelatedInformation{
+			Location: lsp.Location{URI: string(d.URI), Range: d.Range},
+			Message:  message,
+		})
+
+		for _, ancestor := range ancestors {
+			if seen[string(ancestor.URN)] {
+				continue
 			}
-			rawData, err := json.Marshal(data)
+			seen[string(ancestor.URN)] = true
+			uri, capture, err := s.locateResource(ctx, fileCaptures, ancestor)
 			if err != nil {
-				slog.ErrorContext(ctx, "error marshalling capture", "error", err)
 				continue
 			}
-			msg := json.RawMessage(rawData)
-			diags = append(diags, &Diagnostic{
-				Range: lsp.Range{
-					Start: lsp.Position{
-						Line:      int32(diagCapture.StartPoint.Row),
-						Character: int32(diagCapture.StartPoint.Column),
-					},
-					End: lsp.Position{
-						Line:      int32(diagCapture.EndPoint.Row),
-						Character: int32(diagCapture.EndPoint.Column),
-					},
-				},
-				Data:     &msg,
-				URI:      uri,
-				Message:  diag.Message,
-				Severity: enforcementLevelToSeverity(diag.EnforcementLevel),
-				Source:   DiagnosticSource(diag.PolicyName),
+			d.Related = append(d.Related, lsp.DiagnosticRelatedInformation{
+				Location: lsp.Location{URI: string(uri), Range: captureRange(capture)},
+				Message:  fmt.Sprintf("component %s: %s", ancestor.LogicalName(), ancestor.URN),
 			})
 		}
-		if len(diags) > 0 {
-			if d, ok := diagnostics[uri]; ok {
-				// merge the diagnostics
-				diags = append(d, diags...)
-				diagnostics[uri] = diags
-			} else {
-				diagnostics[uri] = diags
-			}
-			diagnostics[uri] = diags
-		}
 	}
-
-	return diagnostics, nil
 }
 
-func findCaptureWithStartLine(captures []parser.CaptureInfo, line int32) *parser.CaptureInfo {
-	for _, capture := range captures {
-		if capture.StartPoint.Row == uint(line) {
-			return &capture
+// componentAncestors returns the components that info is nested under,
+// nearest first. The root stack resource is not included.
+func componentAncestors(resources map[string]*pulumicommand.ResourceInfo, info *pulumicommand.ResourceInfo) []*pulumicommand.ResourceInfo {
+	var ancestors []*pulumicommand.ResourceInfo
+	for parent := info.Parent; parent != ""; {
+		// guard against cycles in malformed parent chains
+		if parent.Type() == tokens.RootStackType || len(ancestors) >= len(resources) {
+			break
+		}
+		ancestor, ok := resources[string(parent)]
+		if !ok {
+			break
 		}
+		ancestors = append(ancestors, ancestor)
+		parent = ancestor.Parent
+	}
+	return ancestors
+}
+
+func captureRange(capture *parser.CaptureInfo) lsp.Range {
+	return lsp.Range{
+		Start: lsp.Position{
+			Line:      int32(capture.StartPoint.Row),
+			Character: int32(capture.StartPoint.Column),
+		},
+		End: lsp.Position{
+			Line:      int32(capture.EndPoint.Row),
+			Character: int32(capture.EndPoint.Column),
+		},
 	}
-	return nil
 }
 
 func enforcementLevelToSeverity(level rpc.EnforcementLevel) lsp.DiagnosticSeverity {
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/corymhall/pulumilsp/pulumicommand"
+	"github.com/hexops/autogold/v2"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/urn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotateInstances(t *testing.T) {
+	resource := func(name string, parent urn.URN) *pulumicommand.ResourceInfo {
+		return &pulumicommand.ResourceInfo{
+			URN:    urn.URN("urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::" + name),
+			Parent: parent,
+		}
+	}
+	stack := urn.URN("urn:pulumi:dev::proj::pulumi:pulumi:Stack::proj-dev")
+	component := &pulumicommand.ResourceInfo{
+		URN:    urn.URN("urn:pulumi:dev::proj::my:index:Site::web"),
+		Parent: stack,
+	}
+	resources := map[string]*pulumicommand.ResourceInfo{
+		string(component.URN): component,
+	}
+
+	t.Run("single top level resource", func(t *testing.T) {
+		group := &diagnosticGroup{
+			diagnostic: &Diagnostic{URI: "file:///index.ts", Message: "bucket must be encrypted"},
+			instances:  []*pulumicommand.ResourceInfo{resource("logs", stack)},
+		}
+		(&server{}).annotateInstances(context.Background(), nil, resources, group)
+		autogold.Expect(&Diagnostic{URI: lsp.DocumentURI("file:///index.ts"), Message: "bucket must be encrypted"}).Equal(t, group.diagnostic)
+	})
+
+	t.Run("loop inside a component", func(t *testing.T) {
+		group := &diagnosticGroup{
+			diagnostic: &Diagnostic{URI: "file:///index.ts", Message: "bucket must be encrypted"},
+			instances: []*pulumicommand.ResourceInfo{
+				resource("logs-1", component.URN),
+				resource("logs-0", component.URN),
+			},
+		}
+		(&server{}).annotateInstances(context.Background(), nil, resources, group)
+		autogold.Expect(&Diagnostic{
+			URI:     lsp.DocumentURI("file:///index.ts"),
+			Message: "bucket must be encrypted (2 resources)",
+			Related: []lsp.DiagnosticRelatedInformation{
+				{
+					Location: lsp.Location{
+						URI: "file:///index.ts",
+					},
+					Message: "logs-0: urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs-0 (in web)",
+				},
+				{
+					Location: lsp.Location{URI: "file:///index.ts"},
+					Message:  "logs-1: urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs-1 (in web)",
+				},
+			},
+		}).Equal(t, group.diagnostic)
+	})
+}
+
+func TestClearViewDiagnostics(t *testing.T) {
+	client := newRecordingClient()
+	web, db := &View{}, &View{}
+	s := &server{
+		client: client,
+		diagnostics: map[lsp.DocumentURI]*fileDiagnostics{
+			"file:///infra/web/index.ts": {byView: map[*View]*viewDiagnostics{
+				web: {diagnostics: []*Diagnostic{{Message: "web bucket"}}},
+			}},
+			"file:///infra/shared/bucket.ts": {byView: map[*View]*viewDiagnostics{
+				web: {diagnostics: []*Diagnostic{{Message: "web bucket"}}},
+				db:  {diagnostics: []*Diagnostic{{Message: "db bucket"}}},
+			}},
+			"file:///infra/db/index.ts": {byView: map[*View]*viewDiagnostics{
+				db: {diagnostics: []*Diagnostic{{Message: "db bucket"}}},
+			}},
+		},
+	}
+
+	s.clearViewDiagnostics(context.Background(), web)
+	autogold.Expect(map[lsp.DocumentURI][]lsp.Diagnostic{
+		lsp.DocumentURI("file:///infra/shared/bucket.ts"): {{Message: "db bucket"}},
+		lsp.DocumentURI("file:///infra/web/index.ts"):     {},
+	}).Equal(t, client.lastPublished())
+}
+
+func TestDiagnoseViewsIndependently(t *testing.T) {
+	ctx := context.Background()
+	s := &server{}
+	web := &View{viewDefinition: &viewDefinition{root: "file:///infra/web"}, diagnosticsSema: make(chan unit, 1)}
+	db := &View{viewDefinition: &viewDefinition{root: "file:///infra/db"}, diagnosticsSema: make(chan unit, 1)}
+
+	// a preview of web is running
+	web.diagnosticsSema <- unit{}
+	_, err := s.diagnose(ctx, &Snapshot{view: db}, FromInitialWorkspaceLoad, nil)
+	require.EqualError(t, err, "no runner", "db isn't queued behind web")
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = s.diagnose(ctx, &Snapshot{view: web}, FromInitialWorkspaceLoad, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/corymhall/pulumilsp/parser"
+	"github.com/corymhall/pulumilsp/pulumicommand"
+)
+
+// pulumiSource is the source of diagnostics of errors that made a preview
+// fail.
+const pulumiSource DiagnosticSource = "pulumi"
+
+// engineErrorDiagnostic returns the diagnostic of an error that made the
+// preview fail. Errors about a resource are reported on the resource, or on
+// the property the error is about if it is set in the program. Program
+// exceptions are reported on the innermost frame of their stack trace that is
+// in the project, and other errors on the project's Pulumi.yaml.
+func (s *server) engineErrorDiagnostic(
+	ctx context.Context,
+	snapshot *Snapshot,
+	fileCaptures map[lsp.DocumentURI][]parser.CaptureInfo,
+	engineErr *pulumicommand.EngineError,
+) *Diagnostic {
+	d := &Diagnostic{
+		Severity: 1, // error
+		Source:   pulumiSource,
+		Message:  engineErr.Message,
+	}
+
+	if pos := engineErr.SourcePosition; pos != nil {
+		if engineErr.URN.IsValid() {
+			info := &pulumicommand.ResourceInfo{URN: engineErr.URN, SourcePosition: pos}
+			if uri, capture, err := s.locateResource(ctx, fileCaptures, info); err == nil {
+				d.URI, d.Range = uri, captureRange(capture)
+				if engineErr.Property != "" {
+					if prop, ok := capture.Property(engineErr.Property); ok {
+						d.Range = propertyRange(prop)
+					}
+				}
+				return d
+			}
+		}
+		frame := pulumicommand.StackFrame{URI: pos.Uri, Line: int(pos.Line), Column: int(pos.Column)}
+		if uri, rng, ok := frameRange(ctx, snapshot, frame); ok {
+			d.URI, d.Range = uri, rng
+			return d
+		}
+	}
+	for _, frame := range engineErr.Frames {
+		if uri, rng, ok := frameRange(ctx, snapshot, frame); ok {
+			d.URI, d.Range = uri, rng
+			return d
+		}
+	}
+
+	d.URI = snapshot.view.pulumiyaml
+	if engineErr.URN.IsValid() {
+		d.Message = fmt.Sprintf("%s: %s", engineErr.URN.Name(), d.Message)
+	}
+	return d
+}
+
+func propertyRange(prop parser.PropertyInfo) lsp.Range {
+	return lsp.Range{
+		Start: lsp.Position{
+			Line:      int32(prop.StartPoint.Row),
+			Character: int32(prop.StartPoint.Column),
+		},
+		End: lsp.Position{
+			Line:      int32(prop.EndPoint.Row),
+			Character: int32(prop.EndPoint.Column),
+		},
+	}
+}
+
+// frameRange returns the range of the line of frame, from its column if
+// known, if the file is part of the project of snapshot rather than one of
+// its dependencies.
+func frameRange(ctx context.Context, snapshot *Snapshot, frame pulumicommand.StackFrame) (lsp.DocumentURI, lsp.Range, bool) {
+	uri := lsp.DocumentURI(frame.URI)
+	if frame.Line < 1 || nearestView([]*View{snapshot.view}, uri) == nil {
+		return "", lsp.Range{}, false
+	}
+	rel, err := filepath.Rel(snapshot.view.root.Path(), uri.Path())
+	if err != nil || slices.ContainsFunc(strings.Split(rel, string(filepath.Separator)), func(name string) bool {
+		return linkedDirs[name]
+	}) {
+		return "", lsp.Range{}, false
+	}
+	fh, err := snapshot.ReadFile(ctx, uri)
+	if err != nil {
+		return "", lsp.Range{}, false
+	}
+	content, err := fh.Content()
+	if err != nil {
+		return "", lsp.Range{}, false
+	}
+	lines := strings.Split(string(content), "\n")
+	if frame.Line > len(lines) {
+		return "", lsp.Range{}, false
+	}
+	line := strings.TrimRight(lines[frame.Line-1], " \t\r")
+	end := utf16Len(line)
+	start := utf16Len(line[:len(line)-len(strings.TrimLeft(line, " \t"))])
+	if frame.Column > 0 {
+		start = min(int32(frame.Column-1), end)
+	}
+	return uri, lsp.Range{
+		Start: lsp.Position{Line: int32(frame.Line - 1), Character: start},
+		End:   lsp.Position{Line: int32(frame.Line - 1), Character: end},
+	}, true
+}
+
+func utf16Len(s string) int32 {
+	var n int32
+	for _, r := range s {
+		n += int32(utf16.RuneLen(r))
+	}
+	return n
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/corymhall/pulumilsp/parser"
+	"github.com/corymhall/pulumilsp/pulumicommand"
+	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineErrorDiagnostic(t *testing.T) {
+	root := t.TempDir()
+	for rel, content := range map[string]string{
+		"Pulumi.yaml":               "name: proj\n",
+		"index.ts":                  "import * as aws from '@pulumi/aws';\n\n    const id = bucket.id.apply(fail);  \n",
+		"node_modules/lib/index.js": "throw new Error('boom');\n",
+		"../other/index.ts":         "export {};\n",
+	} {
+		path := filepath.Join(root, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	uri := func(rel string) string { return string(lsp.URIFromPath(filepath.Join(root, rel))) }
+	view := &View{viewDefinition: &viewDefinition{
+		root:       lsp.URIFromPath(root),
+		pulumiyaml: lsp.URIFromPath(filepath.Join(root, "Pulumi.yaml")),
+	}}
+	snapshot := &Snapshot{view: view, files: make(fileMap)}
+	diagnose := func(engineErr *pulumicommand.EngineError) *Diagnostic {
+		return (&server{}).engineErrorDiagnostic(context.Background(), snapshot, nil, engineErr)
+	}
+
+	t.Run("innermost frame in the project", func(t *testing.T) {
+		d := diagnose(&pulumicommand.EngineError{
+			Message: "Error: boom",
+			Frames: []pulumicommand.StackFrame{
+				{URI: uri("node_modules/lib/index.js"), Line: 1, Column: 7},
+				{URI: uri("../other/index.ts"), Line: 1},
+				{URI: uri("index.ts"), Line: 3},
+			},
+		})
+		require.Equal(t, &Diagnostic{
+			URI:      lsp.DocumentURI(uri("index.ts")),
+			Range:    lsp.Range{Start: lsp.Position{Line: 2, Character: 4}, End: lsp.Position{Line: 2, Character: 37}},
+			Severity: 1,
+			Source:   pulumiSource,
+			Message:  "Error: boom",
+		}, d)
+	})
+
+	t.Run("column", func(t *testing.T) {
+		d := diagnose(&pulumicommand.EngineError{
+			Frames: []pulumicommand.StackFrame{{URI: uri("index.ts"), Line: 3, Column: 15}},
+		})
+		require.Equal(t, lsp.Range{Start: lsp.Position{Line: 2, Character: 14}, End: lsp.Position{Line: 2, Character: 37}}, d.Range)
+	})
+
+	t.Run("source position of a resource", func(t *testing.T) {
+		d := diagnose(&pulumicommand.EngineError{
+			SourcePosition: &rpc.SourcePosition{Uri: uri("index.ts"), Line: 1},
+			Message:        "resource has invalid inputs",
+		})
+		require.Equal(t, lsp.DocumentURI(uri("index.ts")), d.URI)
+		require.Equal(t, lsp.Range{Start: lsp.Position{Line: 0}, End: lsp.Position{Line: 0, Character: 35}}, d.Range)
+	})
+
+	t.Run("property of a resource", func(t *testing.T) {
+		text := "new aws.s3.BucketV2('logs', {\n  acl: 'public',\n  versioning: { enabled: 'yes' },\n});\n"
+		napper, err := parser.NewResourceNapper(parser.TypeScript)
+		require.NoError(t, err)
+		defer napper.Close()
+		captures, err := napper.GetCapturesFromFile([]byte(text))
+		require.NoError(t, err)
+		fileCaptures := map[lsp.DocumentURI][]parser.CaptureInfo{lsp.DocumentURI(uri("bucket.ts")): captures}
+
+		rangeOf := func(property string) lsp.Range {
+			d := (&server{}).engineErrorDiagnostic(context.Background(), snapshot, fileCaptures, &pulumicommand.EngineError{
+				URN:            "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs",
+				SourcePosition: &rpc.SourcePosition{Uri: uri("bucket.ts"), Line: 1},
+				Property:       property,
+			})
+			require.Equal(t, lsp.DocumentURI(uri("bucket.ts")), d.URI)
+			return d.Range
+		}
+		require.Equal(t, lsp.Range{Start: lsp.Position{Line: 1, Character: 2}, End: lsp.Position{Line: 1, Character: 15}}, rangeOf("acl"))
+		require.Equal(t, lsp.Range{Start: lsp.Position{Line: 2, Character: 16}, End: lsp.Position{Line: 2, Character: 30}}, rangeOf("versioning.enabled"))
+		// a missing property is reported on the whole resource
+		require.Equal(t, lsp.Range{End: lsp.Position{Line: 3, Character: 2}}, rangeOf("bucket"))
+	})
+
+	t.Run("no location", func(t *testing.T) {
+		d := diagnose(&pulumicommand.EngineError{
+			URN:     "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs",
+			Message: "bucket name is taken",
+			Frames:  []pulumicommand.StackFrame{{URI: uri("index.ts"), Line: 10}},
+		})
+		require.Equal(t, view.pulumiyaml, d.URI)
+		require.Equal(t, "logs: bucket name is taken", d.Message)
+	})
+}
@@ -28,6 +28,30 @@ type brokenFile struct {
 func (b brokenFile) URI() lsp.DocumentURI     { return b.uri }
 func (b brokenFile) Version() int32           { return 0 }
 func (b brokenFile) Content() ([]byte, error) { return nil, b.err }
+func (b brokenFile) Hash() file.Hash          { return file.Hash{} }
+
+// An overlay is a file open in the editor, whose content may differ from
+// the file on disk.
+type overlay struct {
+	uri     lsp.DocumentURI
+	version int32
+	content []byte
+	hash    file.Hash
+}
+
+func newOverlay(m file.Modification) *overlay {
+	return &overlay{
+		uri:     m.URI,
+		version: m.Version,
+		content: m.Text,
+		hash:    file.HashOf(m.Text),
+	}
+}
+
+func (o *overlay) URI() lsp.DocumentURI     { return o.uri }
+func (o *overlay) Version() int32           { return o.version }
+func (o *overlay) Content() ([]byte, error) { return o.content, nil }
+func (o *overlay) Hash() file.Hash          { return o.hash }
 
 // A diskFile is a file in the filesystem, or a failure to read one.
 // It implements the file.Source interface.
@@ -42,6 +66,7 @@ func (h *diskFile) URI() lsp.DocumentURI { return h.uri }
 
 func (h *diskFile) Version() int32           { return 0 }
 func (h *diskFile) Content() ([]byte, error) { return h.content, h.err }
+func (h *diskFile) Hash() file.Hash          { return h.hash }
 
 // ReadFile stats and (maybe) reads the file, updates the cache, and returns it.
 func ReadFile(ctx context.Context, uri lsp.DocumentURI) (file.Handle, error) {
@@ -2,22 +2,14 @@ package server
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"log/slog"
-	"strings"
 
 	"github.com/corymhall/pulumilsp/debug"
-	"github.com/corymhall/pulumilsp/logger"
 	"github.com/corymhall/pulumilsp/lsp"
 	"github.com/corymhall/pulumilsp/rpc"
 )
 
-type InitOptions struct {
-	LogLevel *string `json:"logLevel,omitempty"`
-}
-
 func (s *server) Initialize(ctx context.Context, params *lsp.InitializeRequestParams) (*lsp.InitializeResult, error) {
 	s.stateMu.Lock()
 	if s.state >= serverInitializing {
@@ -26,33 +18,39 @@ func (s *server) Initialize(ctx context.Context, params *lsp.InitializeRequestPa
 	}
 	if params.InitializationOptions != nil {
 		debug.Info.Log(ctx, "Received initialization options", "options", string(*params.InitializationOptions))
-		var options InitOptions
-		if err := json.Unmarshal(*params.InitializationOptions, &options); err != nil {
-			return nil, fmt.Errorf("error unmarshalling initialization options: %w", err)
-		}
-		var level slog.Level
-		if options.LogLevel != nil {
-			switch strings.ToLower(*options.LogLevel) {
-			case "debug":
-				level = slog.LevelDebug
-			case "info":
-				level = slog.LevelInfo
-			case "warn":
-				level = slog.LevelWarn
-			case "error":
-				level = slog.LevelError
-			}
-			debug.Info.Log(ctx, "Setting log level", "level", level)
-			logger.ProgramLevel.Set(level)
+		// like invalid pulled settings, invalid options fall back to the
+		// defaults rather than leaving the server unusable
+		settings, err := parseSettings(s.defaults, *params.InitializationOptions)
+		if err != nil {
+			debug.LogError(ctx, "invalid initialization options", err)
+		} else {
+			s.initOptions = settings
+			s.defaults = settings
 		}
 	}
+	s.applyGlobalSettings(ctx, s.defaults)
 	s.progress.SetSupportsWorkDoneProgress(params.Capabilities.Window.WorkDoneProgress)
+	s.supportsConfiguration = params.Capabilities.Workspace.Configuration
 	s.state = serverInitializing
 	s.stateMu.Unlock()
-	s.rootURI = params.RootURI
+	for _, folder := range params.WorkspaceFolders {
+		s.folders = append(s.folders, folder.URI)
+	}
+	if len(s.folders) == 0 && params.RootURI != "" {
+		s.folders = []lsp.DocumentURI{params.RootURI}
+	}
 	return &lsp.InitializeResult{
 		Capabilities: lsp.ServerCapabilities{
-			TextDocumentSync: 1,
+			TextDocumentSync: lsp.TextDocumentSyncIncremental,
+			ExecuteCommandProvider: &lsp.ExecuteCommandOptions{
+				Commands: []string{selectStackCommand},
+			},
+			Workspace: &lsp.WorkspaceServerCapabilities{
+				WorkspaceFolders: &lsp.WorkspaceFoldersServerCapabilities{
+					Supported:           true,
+					ChangeNotifications: true,
+				},
+			},
 			// Don't enable this yet, just a hackathon idea
 			// CodeActionProvider: lsp.CodeActionProviderOptions{
 			// 	ResolveProvider: true,
@@ -79,11 +77,12 @@ func (s *server) Initialized(ctx context.Context, params *lsp.InitializedParams)
 	s.state = serverInitialized
 	s.stateMu.Unlock()
 
-	// when we've initialized create the view
+	// when we've initialized pull the settings and create the views
 	// do this in a separate goroutine, otherwise it will
 	// block from receiving more requests
 	go func() {
-		s.initializeView(ctx)
+		s.applyGlobalSettings(ctx, s.settingsFor(ctx, nil))
+		s.updateViews(ctx)
 	}()
 	return nil
 }
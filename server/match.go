@@ -0,0 +1,101 @@
+package server
+
+import (
+	"github.com/corymhall/pulumilsp/parser"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/urn"
+)
+
+// matchCapture returns the capture that declared the resource with the given
+// URN, or nil if no capture could be found.
+//
+// Source positions reported by the engine are not precise enough to match on
+// alone: the reported line is not always the line the constructor call
+// starts on (e.g. `const b =\n  new Bucket(...)`), and for some runtimes
+// (e.g. YAML) it is missing or approximate. Captures are matched by, in order:
+//
+//  1. the URN's logical name and type name
+//  2. the URN's type name and a dynamic name that resolves to the logical name
+//  3. the URN's logical name alone
+//  4. the innermost capture whose range encloses the source line
+//
+// If several captures match, the one nearest to the source line wins.
+func matchCapture(captures []parser.CaptureInfo, resourceURN urn.URN, line int) *parser.CaptureInfo {
+	name := resourceURN.Name()
+	typeName := string(resourceURN.Type().Name())
+
+	staticName := func(c *parser.CaptureInfo) bool { return !c.DynamicName && c.ResourceName == name }
+	dynamicName := func(c *parser.CaptureInfo) bool { return c.DynamicName && c.MatchesName(name) }
+	sameType := func(c *parser.CaptureInfo) bool { return c.ResourceTypeName == typeName }
+
+	if capture := nearestCapture(filterCaptures(captures, staticName, sameType), line); capture != nil {
+		return capture
+	}
+	if capture := nearestCapture(filterCaptures(captures, dynamicName, sameType), line); capture != nil {
+		return capture
+	}
+	if capture := nearestCapture(filterCaptures(captures, staticName), line); capture != nil {
+		return capture
+	}
+	return enclosingCapture(captures, line)
+}
+
+// filterCaptures returns the captures that satisfy every predicate.
+func filterCaptures(captures []parser.CaptureInfo, predicates ...func(*parser.CaptureInfo) bool) []*parser.CaptureInfo {
+	var filtered []*parser.CaptureInfo
+outer:
+	for i := range captures {
+		for _, predicate := range predicates {
+			if !predicate(&captures[i]) {
+				continue outer
+			}
+		}
+		filtered = append(filtered, &captures[i])
+	}
+	return filtered
+}
+
+// nearestCapture returns the capture whose range is closest to line.
+func nearestCapture(captures []*parser.CaptureInfo, line int) *parser.CaptureInfo {
+	var nearest *parser.CaptureInfo
+	nearestDistance := -1
+	for _, capture := range captures {
+		distance := lineDistance(capture, line)
+		if nearest == nil || distance < nearestDistance {
+			nearest, nearestDistance = capture, distance
+		}
+	}
+	return nearest
+}
+
+// enclosingCapture returns the smallest capture whose range contains line.
+func enclosingCapture(captures []parser.CaptureInfo, line int) *parser.CaptureInfo {
+	var enclosing *parser.CaptureInfo
+	for i := range captures {
+		capture := &captures[i]
+		if lineDistance(capture, line) != 0 {
+			continue
+		}
+		if enclosing == nil || captureLines(capture) < captureLines(enclosing) {
+			enclosing = capture
+		}
+	}
+	return enclosing
+}
+
+// lineDistance returns the number of lines between line and the range of
+// capture, or 0 if the capture contains line.
+func lineDistance(capture *parser.CaptureInfo, line int) int {
+	start, end := int(capture.StartPoint.Row), int(capture.EndPoint.Row)
+	switch {
+	case line < start:
+		return start - line
+	case line > end:
+		return line - end
+	default:
+		return 0
+	}
+}
+
+func captureLines(capture *parser.CaptureInfo) int {
+	return int(capture.EndPoint.Row - capture.StartPoint.Row)
+}
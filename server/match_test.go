@@ -0,0 +1,111 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/corymhall/pulumilsp/parser"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/urn"
+	"github.com/stretchr/testify/require"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+func TestMatchCapture(t *testing.T) {
+	captures := []parser.CaptureInfo{
+		{
+			ResourceName:     "logs",
+			ResourceTypeName: "BucketV2",
+			StartPoint:       tree_sitter.Point{Row: 3},
+			EndPoint:         tree_sitter.Point{Row: 5},
+		},
+		{
+			ResourceName:     "logs",
+			ResourceTypeName: "BucketPolicy",
+			StartPoint:       tree_sitter.Point{Row: 7},
+			EndPoint:         tree_sitter.Point{Row: 9},
+		},
+		{
+			ResourceName:     "site",
+			ResourceTypeName: "BucketV2",
+			StartPoint:       tree_sitter.Point{Row: 12},
+			EndPoint:         tree_sitter.Point{Row: 20},
+		},
+		{
+			ResourceName:     "site-policy",
+			ResourceTypeName: "BucketPolicy",
+			StartPoint:       tree_sitter.Point{Row: 15},
+			EndPoint:         tree_sitter.Point{Row: 17},
+		},
+		{
+			ResourceName:     "`${prefix}-assets`",
+			DynamicName:      true,
+			ResourceTypeName: "BucketV2",
+			StartPoint:       tree_sitter.Point{Row: 25},
+			EndPoint:         tree_sitter.Point{Row: 25},
+		},
+		{
+			ResourceName:     "name",
+			DynamicName:      true,
+			ResourceTypeName: "Topic",
+			StartPoint:       tree_sitter.Point{Row: 27},
+			EndPoint:         tree_sitter.Point{Row: 27},
+		},
+	}
+	bucketURN := func(name string) urn.URN {
+		return urn.URN("urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::" + name)
+	}
+
+	tests := []struct {
+		name     string
+		urn      urn.URN
+		line     int
+		expected *parser.CaptureInfo
+	}{
+		{
+			name:     "name and type",
+			urn:      urn.URN("urn:pulumi:dev::proj::aws:s3/bucketPolicy:BucketPolicy::logs"),
+			line:     3,
+			expected: &captures[1],
+		},
+		{
+			name:     "constructor on a later line",
+			urn:      bucketURN("logs"),
+			line:     2,
+			expected: &captures[0],
+		},
+		{
+			name:     "no source line",
+			urn:      bucketURN("site"),
+			line:     -1,
+			expected: &captures[2],
+		},
+		{
+			name:     "innermost enclosing capture",
+			urn:      bucketURN("unknown"),
+			line:     16,
+			expected: &captures[3],
+		},
+		{
+			name:     "template name",
+			urn:      bucketURN("app-assets"),
+			line:     -1,
+			expected: &captures[4],
+		},
+		{
+			name:     "variable name",
+			urn:      urn.URN("urn:pulumi:dev::proj::aws:sns/topic:Topic::alerts"),
+			line:     40,
+			expected: &captures[5],
+		},
+		{
+			name:     "no match",
+			urn:      urn.URN("urn:pulumi:dev::proj::aws:sqs/queue:Queue::jobs"),
+			line:     40,
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, matchCapture(captures, tt.urn, tt.line))
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/corymhall/pulumilsp/file"
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/corymhall/pulumilsp/parser"
+)
+
+// napperLanguages maps the file kinds we can extract resources from to the
+// parser language used to do so.
+var napperLanguages = map[file.Kind]parser.Language{
+	file.TypeScript: parser.TypeScript,
+	file.TSX:        parser.TSX,
+	file.JavaScript: parser.JavaScript,
+	file.Python:     parser.Python,
+	file.Go:         parser.Go,
+	file.CSharp:     parser.CSharp,
+}
+
+// napperLanguage returns the parser language for the kind of file at uri.
+func napperLanguage(uri lsp.DocumentURI) (parser.Language, error) {
+	lang, ok := napperLanguages[file.KindForURI(uri)]
+	if !ok {
+		return 0, fmt.Errorf("no resource parser for %s", uri)
+	}
+	return lang, nil
+}
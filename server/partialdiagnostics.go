@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/corymhall/pulumilsp/debug"
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/corymhall/pulumilsp/parser"
+	"github.com/corymhall/pulumilsp/pulumicommand"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// partialDiagnosticsInterval is the minimum time between two publications of
+// partial diagnostics, so that resources analyzed in quick succession are
+// published together.
+var partialDiagnosticsInterval = 500 * time.Millisecond
+
+// partialDiagnostics publishes the policy violations of the resources that
+// were analyzed while a preview runs, so that the violations of large stacks
+// show up before the preview completes. The diagnostics of the completed
+// preview replace them, and if the preview fails or is cancelled the
+// diagnostics of the last completed preview are published again. It also
+// reports the progress of the preview, relative to the number of resources of
+// the previous preview.
+type partialDiagnostics struct {
+	s        *server
+	snapshot *Snapshot
+	work     *WorkDone
+	// projectURI maps the source positions of the preview to the project,
+	// e.g. for a preview of the shadow workspace.
+	projectURI func(string) string
+	// total is the number of resources of the previous preview, or 0.
+	total int
+
+	mu               sync.Mutex
+	resources        map[string]*pulumicommand.ResourceInfo
+	resourcesChanged bool
+	registered       int
+	analyzed         int
+
+	changed chan struct{}
+	stopped chan struct{}
+	done    chan struct{}
+
+	// only accessed by run
+	fileCaptures map[lsp.DocumentURI][]parser.CaptureInfo
+	published    diagMap
+	reported     pulumicommand.Progress
+}
+
+// startPartialDiagnostics starts publishing the partial diagnostics of a
+// preview of snapshot, until stop is called.
+func (s *server) startPartialDiagnostics(ctx context.Context, snapshot *Snapshot, work *WorkDone, projectURI func(string) string) *partialDiagnostics {
+	p := &partialDiagnostics{
+		s:            s,
+		snapshot:     snapshot,
+		work:         work,
+		projectURI:   projectURI,
+		total:        snapshot.view.lastPreviewSize(),
+		resources:    make(map[string]*pulumicommand.ResourceInfo),
+		changed:      make(chan struct{}, 1),
+		stopped:      make(chan struct{}),
+		done:         make(chan struct{}),
+		fileCaptures: make(map[lsp.DocumentURI][]parser.CaptureInfo),
+		published:    make(diagMap),
+	}
+	go p.run(ctx)
+	return p
+}
+
+// option returns the preview option that reports the progress of the preview
+// to p.
+func (p *partialDiagnostics) option() optpreview.Option {
+	return pulumicommand.OnProgress(p.report)
+}
+
+// report records the progress of the preview. It is called while the events
+// of the preview are handled, so the diagnostics are published by run.
+func (p *partialDiagnostics) report(progress pulumicommand.Progress) {
+	p.mu.Lock()
+	p.registered = progress.Registered
+	p.analyzed = progress.Analyzed
+	if resource := progress.Resource; resource != nil {
+		if p.projectURI != nil {
+			pos := proto.Clone(resource.SourcePosition).(*rpc.SourcePosition)
+			pos.Uri = p.projectURI(pos.Uri)
+			resource.SourcePosition = pos
+		}
+		p.resources[string(resource.URN)] = resource
+		p.resourcesChanged = true
+	}
+	p.mu.Unlock()
+
+	select {
+	case p.changed <- struct{}{}:
+	default:
+		// already pending
+	}
+}
+
+func (p *partialDiagnostics) run(ctx context.Context) {
+	defer close(p.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stopped:
+			return
+		case <-p.changed:
+		}
+		p.publish(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stopped:
+			return
+		case <-time.After(partialDiagnosticsInterval):
+		}
+	}
+}
+
+// publish reports the progress of the preview, and publishes the diagnostics
+// of the files whose diagnostics changed since they were last published.
+func (p *partialDiagnostics) publish(ctx context.Context) {
+	p.mu.Lock()
+	progress := pulumicommand.Progress{Registered: p.registered, Analyzed: p.analyzed}
+	var resources map[string]*pulumicommand.ResourceInfo
+	if p.resourcesChanged {
+		resources = maps.Clone(p.resources)
+		p.resourcesChanged = false
+	}
+	p.mu.Unlock()
+
+	if progress != p.reported {
+		p.work.Report(ctx, progressMessage(progress), progressPercentage(progress.Registered, p.total))
+		p.reported = progress
+	}
+	if resources == nil {
+		return
+	}
+
+	changed := make(diagMap)
+	for uri, diags := range p.s.policyDiagnostics(ctx, p.snapshot.settings, p.fileCaptures, resources) {
+		if !reflect.DeepEqual(p.published[uri], diags) {
+			changed[uri] = diags
+			p.published[uri] = diags
+		}
+	}
+	if len(changed) > 0 {
+		p.s.updatePartialDiagnostics(ctx, p.snapshot, changed)
+	}
+}
+
+// registeredResources returns the number of resources registered so far.
+func (p *partialDiagnostics) registeredResources() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.registered
+}
+
+// progressMessage returns the message reporting the progress of a preview,
+// e.g. "Registered 42 resources / analyzed 17".
+func progressMessage(progress pulumicommand.Progress) string {
+	msg := fmt.Sprintf("Registered %d resources", progress.Registered)
+	if progress.Analyzed > 0 {
+		msg += fmt.Sprintf(" / analyzed %d", progress.Analyzed)
+	}
+	return msg
+}
+
+// progressPercentage estimates the percentage of a preview that is done from
+// the number of resources registered so far and the number of resources of
+// the previous preview, or returns 0 if there is no previous preview. It
+// stays below 100 until the preview completes, since the program may
+// register more resources than before.
+func progressPercentage(registered, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return min(float64(registered)*100/float64(total), 99)
+}
+
+// stop stops publishing partial diagnostics, and waits for a publication in
+// progress to complete.
+func (p *partialDiagnostics) stop() {
+	close(p.stopped)
+	<-p.done
+}
+
+// updatePartialDiagnostics publishes the diagnostics of a preview of
+// snapshot that is still running. Unlike updateDiagnostics, the diagnostics
+// of other files are left as they are, and the diagnostics of the last
+// completed preview are kept until the preview completes.
+func (s *server) updatePartialDiagnostics(ctx context.Context, snapshot *Snapshot, diagnostics diagMap) {
+	s.diagnosticsMu.Lock()
+	defer s.diagnosticsMu.Unlock()
+	if ctx.Err() != nil || snapshot.view.isShutdown() {
+		return
+	}
+	for uri, diags := range diagnostics {
+		fh, err := snapshot.ReadFile(ctx, uri)
+		if err != nil {
+			debug.LogError(ctx, "error updating partial diagnostics", err)
+			continue
+		}
+		f, ok := s.diagnostics[uri]
+		if !ok {
+			f = &fileDiagnostics{}
+			s.diagnostics[uri] = f
+		}
+		if f.partial == nil {
+			f.partial = make(map[*View]*viewDiagnostics)
+		}
+		f.partial[snapshot.view] = &viewDiagnostics{
+			snapshot:    snapshot.SequenceID(),
+			version:     fh.Version(),
+			diagnostics: diags,
+		}
+		if err := s.publishFileDiagnostics(ctx, uri, fh.Version(), f); err != nil && ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// clearPartialDiagnostics drops the partial diagnostics of a preview of
+// snapshot that didn't complete, and publishes the diagnostics of the last
+// completed preview again. The partial diagnostics of a later preview are
+// left alone.
+func (s *server) clearPartialDiagnostics(ctx context.Context, snapshot *Snapshot) {
+	s.diagnosticsMu.Lock()
+	defer s.diagnosticsMu.Unlock()
+	for uri, f := range s.diagnostics {
+		vd, ok := f.partial[snapshot.view]
+		if !ok || vd.snapshot != snapshot.SequenceID() {
+			continue
+		}
+		delete(f.partial, snapshot.view)
+		version := vd.version
+		if complete, ok := f.byView[snapshot.view]; ok {
+			version = complete.version
+		}
+		if err := s.publishFileDiagnostics(ctx, uri, version, f); err != nil {
+			debug.LogError(ctx, "error clearing partial diagnostics", err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/corymhall/pulumilsp/parser"
+	"github.com/corymhall/pulumilsp/pulumicommand"
+	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"github.com/stretchr/testify/require"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+func TestPartialDiagnostics(t *testing.T) {
+	interval := partialDiagnosticsInterval
+	partialDiagnosticsInterval = time.Millisecond
+	defer func() { partialDiagnosticsInterval = interval }()
+
+	root := t.TempDir()
+	path := filepath.Join(root, "index.ts")
+	require.NoError(t, os.WriteFile(path, []byte("const logs = new aws.s3.BucketV2('logs', {});\n"), 0o644))
+	uri := lsp.URIFromPath(path)
+	shadowURI := "file:///tmp/shadow/index.ts"
+
+	client := newRecordingClient()
+	s := &server{client: client, diagnostics: make(map[lsp.DocumentURI]*fileDiagnostics)}
+	view := &View{viewDefinition: &viewDefinition{root: lsp.URIFromPath(root)}, previewedResources: 4}
+	snapshot := &Snapshot{view: view, files: make(fileMap), settings: &Settings{}}
+	view.snapshot = snapshot
+	work := &WorkDone{client: client, token: "preview"}
+
+	p := s.startPartialDiagnostics(context.Background(), snapshot, work, func(u string) string {
+		return strings.Replace(u, shadowURI, string(uri), 1)
+	})
+	p.fileCaptures[uri] = []parser.CaptureInfo{{
+		ResourceName:     "logs",
+		ResourceTypeName: "BucketV2",
+		StartPoint:       tree_sitter.Point{Row: 0, Column: 13},
+		EndPoint:         tree_sitter.Point{Row: 0, Column: 45},
+	}}
+
+	// each report carries its own copy of the resource
+	bucket := func() *pulumicommand.ResourceInfo {
+		return &pulumicommand.ResourceInfo{
+			URN:            "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs",
+			SourcePosition: &rpc.SourcePosition{Uri: shadowURI, Line: 1},
+			Diagnostics: []*rpc.AnalyzeDiagnostic{{
+				PolicyName: "no-public-buckets",
+				Message:    "bucket is public",
+			}},
+		}
+	}
+	reported := func(message string, percentage int) func() bool {
+		return func() bool {
+			return client.lastReport() == lsp.WorkDoneProgressReportValue{Kind: lsp.Report, Message: message, Percentage: percentage}
+		}
+	}
+	p.report(pulumicommand.Progress{Registered: 1})
+	require.Eventually(t, reported("Registered 1 resources", 25), time.Second, time.Millisecond)
+
+	// analyzed before its registration completed
+	p.report(pulumicommand.Progress{Registered: 1, Analyzed: 1})
+	require.Eventually(t, reported("Registered 1 resources / analyzed 1", 25), time.Second, time.Millisecond)
+	require.Empty(t, client.publications(uri))
+
+	p.report(pulumicommand.Progress{Registered: 2, Analyzed: 1, Resource: bucket()})
+	require.Eventually(t, func() bool { return len(client.publications(uri)) == 1 }, time.Second, time.Millisecond)
+	published := client.publications(uri)[0]
+	require.Len(t, published, 1)
+	require.Equal(t, "bucket is public", published[0].Message)
+	require.Equal(t, lsp.Range{Start: lsp.Position{Line: 0, Character: 13}, End: lsp.Position{Line: 0, Character: 45}}, published[0].Range)
+
+	// the diagnostics of the file didn't change, so they aren't published again
+	p.report(pulumicommand.Progress{Registered: 5, Analyzed: 2, Resource: bucket()})
+	require.Eventually(t, reported("Registered 5 resources / analyzed 2", 99), time.Second, time.Millisecond)
+	p.stop()
+	require.Len(t, client.publications(uri), 1)
+	require.Equal(t, 5, p.registeredResources())
+}
+
+func TestPartialDiagnosticsCancelled(t *testing.T) {
+	interval := partialDiagnosticsInterval
+	partialDiagnosticsInterval = time.Millisecond
+	defer func() { partialDiagnosticsInterval = interval }()
+
+	root := t.TempDir()
+	path := filepath.Join(root, "index.ts")
+	require.NoError(t, os.WriteFile(path, []byte("const logs = new aws.s3.BucketV2('logs', {});\n"), 0o644))
+	uri := lsp.URIFromPath(path)
+
+	client := newRecordingClient()
+	s := &server{client: client, diagnostics: make(map[lsp.DocumentURI]*fileDiagnostics)}
+	view := &View{viewDefinition: &viewDefinition{root: lsp.URIFromPath(root)}, diagnosticsSema: make(chan unit, 1)}
+	snapshot := &Snapshot{view: view, files: make(fileMap), settings: &Settings{}}
+	view.snapshot = snapshot
+	work := &WorkDone{client: client, token: "preview"}
+
+	// the last completed preview reported an engine error and a violation
+	complete := diagMap{uri: {
+		{URI: uri, Message: "program failed", Source: "pulumi"},
+		{URI: uri, Message: "bucket is public", Source: "no-public-buckets"},
+	}}
+	s.updateDiagnostics(context.Background(), snapshot, complete)
+	require.Len(t, client.publications(uri), 1)
+
+	// the next preview is cancelled after analyzing the first resource
+	next := &Snapshot{view: view, sequenceID: 1, files: make(fileMap), settings: &Settings{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := s.startPartialDiagnostics(ctx, next, work, nil)
+	p.fileCaptures[uri] = []parser.CaptureInfo{{
+		ResourceName:     "logs",
+		ResourceTypeName: "BucketV2",
+		StartPoint:       tree_sitter.Point{Row: 0, Column: 13},
+		EndPoint:         tree_sitter.Point{Row: 0, Column: 45},
+	}}
+	p.report(pulumicommand.Progress{Registered: 1, Analyzed: 1, Resource: &pulumicommand.ResourceInfo{
+		URN:            "urn:pulumi:dev::proj::aws:s3/bucketV2:BucketV2::logs",
+		SourcePosition: &rpc.SourcePosition{Uri: string(uri), Line: 1},
+		Diagnostics:    []*rpc.AnalyzeDiagnostic{{PolicyName: "required-tags", Message: "bucket has no tags"}},
+	}})
+	require.Eventually(t, func() bool { return len(client.publications(uri)) == 2 }, time.Second, time.Millisecond)
+	published := client.publications(uri)[1]
+	require.Len(t, published, 1)
+	require.Equal(t, "bucket has no tags", published[0].Message)
+	cancel()
+	p.stop()
+
+	s.diagnoseSnapshot(ctx, next, nil, FromDidChange, 0, work)
+	publications := client.publications(uri)
+	require.Len(t, publications, 3)
+	require.Equal(t, publications[0], publications[2])
+}
+
+func TestProgressPercentage(t *testing.T) {
+	require.Equal(t, 0.0, progressPercentage(10, 0))
+	require.Equal(t, 50.0, progressPercentage(10, 20))
+	require.Equal(t, 99.0, progressPercentage(20, 20))
+	require.Equal(t, 99.0, progressPercentage(30, 20))
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/corymhall/pulumilsp/debug"
+	"github.com/corymhall/pulumilsp/lsp"
+	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// policyConfigSource is the source of diagnostics on policy pack config files.
+const policyConfigSource DiagnosticSource = "policy config"
+
+// allPolicies is the key of a policy pack config that sets the enforcement
+// level of all policies.
+const allPolicies = "all"
+
+// enforcementLevels are the enforcement levels a policy can be configured
+// with.
+var enforcementLevels = []any{"advisory", "mandatory", "remediate", "disabled"}
+
+// policyConfigDiagnostics validates the config files of the local policy
+// packs of snapshot against the config schemas of the policies the preview
+// loaded, and returns the errors as diagnostics on the config files. packs
+// are the analyzers whose policy pack is known, by the directory of the pack.
+func policyConfigDiagnostics(ctx context.Context, snapshot *Snapshot, analyzers []*rpc.AnalyzerInfo, packs map[string]*rpc.AnalyzerInfo) diagMap {
+	diagnostics := make(diagMap)
+	root := snapshot.view.root.Path()
+	packPaths := snapshot.settings.policyPackPaths(root)
+	for i, path := range snapshot.settings.policyPackConfigPaths(root) {
+		if path == "" {
+			continue
+		}
+		uri := lsp.URIFromPath(path)
+		fh, err := snapshot.ReadFile(ctx, uri)
+		if err != nil {
+			debug.LogError(ctx, "error reading policy pack config", err)
+			continue
+		}
+		content, err := fh.Content()
+		if err != nil {
+			debug.LogError(ctx, "error reading policy pack config", err)
+			continue
+		}
+		pack := policyPackAnalyzer(packs, packPaths[i])
+		if diags := validatePolicyConfig(uri, content, pack, analyzers); len(diags) > 0 {
+			diagnostics[uri] = diags
+		}
+	}
+	return diagnostics
+}
+
+// policyPackAnalyzer returns the analyzer of the policy pack at path, or nil
+// if the preview didn't report which pack it belongs to.
+func policyPackAnalyzer(packs map[string]*rpc.AnalyzerInfo, path string) *rpc.AnalyzerInfo {
+	if analyzer, ok := packs[filepath.Clean(path)]; ok {
+		return analyzer
+	}
+	// the analyzer may report the directory through a symlink
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	for dir, analyzer := range packs {
+		if dirInfo, err := os.Stat(dir); err == nil && os.SameFile(info, dirInfo) {
+			return analyzer
+		}
+	}
+	return nil
+}
+
+// validatePolicyConfig validates the policy pack config in content against
+// the policies of pack, the analyzer of the policy pack it configures. If the
+// pack is nil because the preview didn't report it, the config is matched to
+// one of analyzers by the names of its policies.
+func validatePolicyConfig(uri lsp.DocumentURI, content []byte, pack *rpc.AnalyzerInfo, analyzers []*rpc.AnalyzerInfo) []*Diagnostic {
+	diagnostic := func(source DiagnosticSource, pointer []string, msg string) *Diagnostic {
+		start, end := locateJSON(content, pointer)
+		return &Diagnostic{
+			URI:      uri,
+			Range:    lsp.Range{Start: offsetPosition(content, start), End: offsetPosition(content, end)},
+			Severity: 1, // error
+			Source:   source,
+			Message:  msg,
+		}
+	}
+
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(content, &config); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			pos := offsetPosition(content, int(syntaxErr.Offset))
+			return []*Diagnostic{{
+				URI:      uri,
+				Range:    lsp.Range{Start: pos, End: pos},
+				Severity: 1, // error
+				Source:   policyConfigSource,
+				Message:  syntaxErr.Error(),
+			}}
+		}
+		return []*Diagnostic{diagnostic(policyConfigSource, nil, "policy pack config must be an object")}
+	}
+	if len(analyzers) == 0 {
+		// the preview failed before the policy packs were loaded
+		return nil
+	}
+
+	analyzer := pack
+	if analyzer == nil {
+		analyzer = matchAnalyzer(analyzers, config)
+	}
+	if analyzer == nil {
+		if len(config) == 0 || (len(config) == 1 && config[allPolicies] != nil) {
+			return nil
+		}
+		return []*Diagnostic{diagnostic(policyConfigSource, nil, "none of the configured policies are in a policy pack of the stack")}
+	}
+
+	var diags []*Diagnostic
+	source := DiagnosticSource(analyzer.Name)
+	for _, name := range slices.Sorted(maps.Keys(config)) {
+		pointer := []string{name}
+		var value any
+		if err := json.Unmarshal(config[name], &value); err != nil {
+			diags = append(diags, diagnostic(source, pointer, err.Error()))
+			continue
+		}
+
+		if name == allPolicies {
+			if level, ok := value.(string); !ok || !slices.Contains(enforcementLevels, any(level)) {
+				diags = append(diags, diagnostic(source, pointer, fmt.Sprintf("%q must be an enforcement level: %s", name, formatEnforcementLevels())))
+			}
+			continue
+		}
+		i := slices.IndexFunc(analyzer.Policies, func(p *rpc.PolicyInfo) bool {
+			return p.Name == name
+		})
+		if i < 0 {
+			diags = append(diags, diagnostic(source, pointer, fmt.Sprintf("policy %q is not in policy pack %q", name, analyzer.Name)))
+			continue
+		}
+		policy := analyzer.Policies[i]
+
+		switch value := value.(type) {
+		case string:
+			if !slices.Contains(enforcementLevels, any(value)) {
+				diags = append(diags, diagnostic(source, pointer, fmt.Sprintf("invalid enforcement level %q, expected one of %s", value, formatEnforcementLevels())))
+			}
+		case map[string]any:
+			schema, err := policyConfigSchema(policy)
+			if err != nil {
+				diags = append(diags, diagnostic(source, pointer, fmt.Sprintf("invalid config schema of policy %q: %v", name, err)))
+				continue
+			}
+			var validationErr *jsonschema.ValidationError
+			if err := schema.Validate(value); errors.As(err, &validationErr) {
+				for _, leaf := range leafValidationErrors(validationErr) {
+					msg := fmt.Sprintf("%s: %s", name, leaf.Message)
+					diags = append(diags, diagnostic(source, append(pointer, parseJSONPointer(leaf.InstanceLocation)...), msg))
+				}
+			}
+		default:
+			diags = append(diags, diagnostic(source, pointer, fmt.Sprintf("the config of policy %q must be an enforcement level or an object", name)))
+		}
+	}
+	return diags
+}
+
+// matchAnalyzer returns the analyzer with the most policies that are
+// configured in config, or nil if none of them are.
+func matchAnalyzer(analyzers []*rpc.AnalyzerInfo, config map[string]json.RawMessage) *rpc.AnalyzerInfo {
+	var best *rpc.AnalyzerInfo
+	bestCount := 0
+	for _, analyzer := range analyzers {
+		count := 0
+		for _, policy := range analyzer.Policies {
+			if _, ok := config[policy.Name]; ok {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = analyzer, count
+		}
+	}
+	return best
+}
+
+// policyConfigSchema returns the JSON schema of the config of policy, which
+// is an object with the properties of the policy's config schema and its
+// enforcement level.
+func policyConfigSchema(policy *rpc.PolicyInfo) (*jsonschema.Schema, error) {
+	properties := map[string]any{}
+	var required []string
+	if policy.ConfigSchema != nil {
+		if policy.ConfigSchema.Properties != nil {
+			properties = policy.ConfigSchema.Properties.AsMap()
+		}
+		required = policy.ConfigSchema.Required
+	}
+	properties["enforcementLevel"] = map[string]any{"enum": enforcementLevels}
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	raw, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	return jsonschema.CompileString(policy.Name+".json", string(raw))
+}
+
+func formatEnforcementLevels() string {
+	levels := make([]string, len(enforcementLevels))
+	for i, level := range enforcementLevels {
+		levels[i] = strconv.Quote(level.(string))
+	}
+	return strings.Join(levels, ", ")
+}
+
+// leafValidationErrors returns the errors that cause err, which describe
+// what is invalid rather than which schema failed.
+func leafValidationErrors(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+	var leaves []*jsonschema.ValidationError
+	for _, cause := range err.Causes {
+		leaves = append(leaves, leafValidationErrors(cause)...)
+	}
+	// the properties of an object are validated in random order
+	slices.SortStableFunc(leaves, func(a, b *jsonschema.ValidationError) int {
+		return strings.Compare(a.InstanceLocation, b.InstanceLocation)
+	})
+	return leaves
+}
+
+// parseJSONPointer returns the reference tokens of a JSON pointer, e.g.
+// "/tags/0" is ["tags", "0"].
+func parseJSONPointer(pointer string) []string {
+	if pointer == "" {
+		return nil
+	}
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens
+}
+
+// locateJSON returns the byte offsets of the value at pointer in the JSON
+// document content. If the value doesn't exist, e.g. a missing required
+// property, it returns the offsets of its nearest ancestor.
+func locateJSON(content []byte, pointer []string) (int, int) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	start, end, err := locateJSONValue(content, dec, pointer)
+	if err != nil {
+		return 0, 0
+	}
+	return start, end
+}
+
+func locateJSONValue(content []byte, dec *json.Decoder, pointer []string) (int, int, error) {
+	start := int(dec.InputOffset())
+	for start < len(content) && strings.IndexByte(" \t\r\n,:", content[start]) >= 0 {
+		start++
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return 0, 0, err
+	}
+	if delim, ok := tok.(json.Delim); ok {
+		for i := 0; dec.More(); i++ {
+			key := strconv.Itoa(i)
+			if delim == '{' {
+				tok, err := dec.Token()
+				if err != nil {
+					return 0, 0, err
+				}
+				key, _ = tok.(string)
+			}
+			if len(pointer) > 0 && key == pointer[0] {
+				return locateJSONValue(content, dec, pointer[1:])
+			}
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return 0, 0, err
+			}
+		}
+		// the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, int(dec.InputOffset()), nil
+}
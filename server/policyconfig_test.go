@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestValidatePolicyConfig(t *testing.T) {
+	properties, err := structpb.NewStruct(map[string]any{
+		"maxBuckets": map[string]any{"type": "integer", "minimum": 1},
+		"tags":       map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+	})
+	require.NoError(t, err)
+	analyzers := []*rpc.AnalyzerInfo{
+		{Name: "cloud", Policies: []*rpc.PolicyInfo{{Name: "s3-no-public-read"}}},
+		{Name: "local", Policies: []*rpc.PolicyInfo{
+			{Name: "max-bucket-count", ConfigSchema: &rpc.PolicyConfigSchema{Properties: properties, Required: []string{"maxBuckets"}}},
+			{Name: "s3-versioning"},
+		}},
+	}
+	validatePack := func(pack *rpc.AnalyzerInfo, content string) []string {
+		var diags []string
+		for _, d := range validatePolicyConfig("file:///policy-config.json", []byte(content), pack, analyzers) {
+			diags = append(diags, fmt.Sprintf("%d:%d-%d:%d %s: %s", d.Range.Start.Line, d.Range.Start.Character, d.Range.End.Line, d.Range.End.Character, d.Source, d.Message))
+		}
+		return diags
+	}
+	validate := func(content string) []string {
+		return validatePack(nil, content)
+	}
+
+	require.Empty(t, validate(`{
+  "all": "advisory",
+  "max-bucket-count": {"enforcementLevel": "mandatory", "maxBuckets": 3, "tags": ["team"]},
+  "s3-versioning": "disabled"
+}`))
+	require.Empty(t, validate(`{"all": "mandatory"}`))
+
+	require.Equal(t, []string{
+		`2:37-2:38 local: max-bucket-count: must be >= 1 but found 0`,
+		`2:49-2:50 local: max-bucket-count: expected string, but got number`,
+		`4:19-4:21 local: policy "s3-encryption" is not in policy pack "local"`,
+		`3:19-3:24 local: invalid enforcement level "off", expected one of "advisory", "mandatory", "remediate", "disabled"`,
+	}, validate(`{
+  "all": "advisory",
+  "max-bucket-count": {"maxBuckets": 0, "tags": [1]},
+  "s3-versioning": "off",
+  "s3-encryption": {}
+}`))
+	require.Equal(t, []string{
+		`0:21-0:53 local: max-bucket-count: missing properties: 'maxBuckets'`,
+	}, validate(`{"max-bucket-count": {"enforcementLevel": "advisory"}}`))
+	require.Equal(t, []string{
+		`0:0-0:29 policy config: none of the configured policies are in a policy pack of the stack`,
+	}, validate(`{"s3-encryption": "advisory"}`))
+	require.Equal(t, []string{
+		`0:19-0:19 policy config: invalid character '}' looking for beginning of value`,
+	}, validate(`{"s3-versioning": }`))
+	require.Empty(t, validatePolicyConfig("file:///policy-config.json", []byte(`{"s3-encryption": "advisory"}`), nil, nil),
+		"configs can't be validated if the preview didn't load the policy packs")
+
+	// configs of a known pack are validated against it, whatever they set
+	local := analyzers[1]
+	require.Equal(t, []string{
+		`0:8-0:13 local: "all" must be an enforcement level: "advisory", "mandatory", "remediate", "disabled"`,
+	}, validatePack(local, `{"all": "off"}`))
+	require.Equal(t, []string{
+		`0:17-0:27 local: policy "s3-versionin" is not in policy pack "local"`,
+	}, validatePack(local, `{"s3-versionin": "advisory"}`))
+	require.Equal(t, []string{
+		`0:22-0:32 local: policy "s3-no-public-read" is not in policy pack "local"`,
+	}, validatePack(local, `{"s3-no-public-read": "advisory"}`))
+}
+
+func TestPolicyPackAnalyzer(t *testing.T) {
+	dir := t.TempDir()
+	pack := filepath.Join(dir, "policies")
+	require.NoError(t, os.Mkdir(pack, 0o755))
+	link := filepath.Join(dir, "link")
+	require.NoError(t, os.Symlink(pack, link))
+	analyzer := &rpc.AnalyzerInfo{Name: "local"}
+	packs := map[string]*rpc.AnalyzerInfo{pack: analyzer}
+
+	require.Same(t, analyzer, policyPackAnalyzer(packs, pack+"/"))
+	require.Same(t, analyzer, policyPackAnalyzer(packs, link))
+	require.Nil(t, policyPackAnalyzer(packs, dir))
+	require.Nil(t, policyPackAnalyzer(nil, pack))
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/corymhall/pulumilsp/debug"
+	"github.com/fsnotify/fsnotify"
+)
+
+// policyWatchDelay is how long to wait after the last change to a policy pack
+// before calling onChange, since saving or building a pack changes several
+// files at once.
+const policyWatchDelay = 500 * time.Millisecond
+
+// A policyWatcher watches the local policy packs of a view and their config
+// files, and calls onChange when any of their files change.
+type policyWatcher struct {
+	ctx      context.Context
+	onChange func()
+
+	mu      sync.Mutex
+	closed  bool
+	packs   []string // the watched policy pack directories
+	configs []string // the watched config files
+	watcher *fsnotify.Watcher
+	timer   *time.Timer // calls onChange once no changes were made for policyWatchDelay
+}
+
+func newPolicyWatcher(ctx context.Context, onChange func()) *policyWatcher {
+	return &policyWatcher{
+		ctx:      ctx,
+		onChange: onChange,
+	}
+}
+
+// watch replaces the watched policy pack directories and config files. Empty
+// config paths are ignored.
+func (w *policyWatcher) watch(packs, configs []string) error {
+	configs = slices.DeleteFunc(slices.Clone(configs), func(config string) bool {
+		return config == ""
+	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errors.New("policy watcher is closed")
+	}
+	if slices.Equal(packs, w.packs) && slices.Equal(configs, w.configs) {
+		return nil
+	}
+	w.stopLocked()
+	w.packs, w.configs = packs, configs
+	if len(packs) == 0 && len(configs) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, pack := range packs {
+		errs = append(errs, addDirs(watcher, pack))
+	}
+	for _, config := range configs {
+		// editors often save a file by replacing it, so watch its directory
+		errs = append(errs, watcher.Add(filepath.Dir(config)))
+	}
+	w.watcher = watcher
+	go w.run(watcher)
+	return errors.Join(errs...)
+}
+
+// close stops watching. onChange is not called for changes made after close
+// returns.
+func (w *policyWatcher) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	w.stopLocked()
+}
+
+func (w *policyWatcher) stopLocked() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.watcher != nil {
+		w.watcher.Close()
+		w.watcher = nil
+	}
+}
+
+func (w *policyWatcher) run(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(watcher, event)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			debug.LogError(w.ctx, "error watching policy packs", err)
+		}
+	}
+}
+
+func (w *policyWatcher) handle(watcher *fsnotify.Watcher, event fsnotify.Event) {
+	if event.Op == fsnotify.Chmod || !w.affects(event.Name) {
+		return
+	}
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := addDirs(watcher, event.Name); err != nil {
+				debug.LogError(w.ctx, "error watching policy pack directory", err)
+			}
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watcher != watcher {
+		// the watched paths were replaced or the watcher was closed
+		return
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(policyWatchDelay, w.onChange)
+}
+
+// affects reports whether a change to path changes a watched policy pack or
+// config file. Dependencies of the packs are ignored.
+func (w *policyWatcher) affects(path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if slices.Contains(w.configs, path) {
+		return true
+	}
+	for _, pack := range w.packs {
+		rel, err := filepath.Rel(pack, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return !slices.ContainsFunc(strings.Split(rel, string(filepath.Separator)), func(name string) bool {
+			return skippedDirs[name] || linkedDirs[name]
+		})
+	}
+	return false
+}
+
+// addDirs watches root and the directories in it, except for dependencies.
+func addDirs(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && (skippedDirs[d.Name()] || linkedDirs[d.Name()]) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// watchPolicyPacks updates the policy packs watched for view to those in
+// settings.
+func (s *server) watchPolicyPacks(ctx context.Context, view *View, settings *Settings) {
+	root := view.root.Path()
+	if err := view.policies.watch(settings.policyPackPaths(root), settings.policyPackConfigPaths(root)); err != nil {
+		debug.LogError(ctx, "error watching policy packs", err)
+	}
+}
+
+// didChangePolicyPacks diagnoses view again after one of its policy packs
+// changed.
+func (s *server) didChangePolicyPacks(ctx context.Context, view *View) {
+	if view.isShutdown() {
+		return
+	}
+	ctx, done := debug.Start(ctx, "didChangePolicyPacks")
+	defer done()
+	modCtx, modID := s.updateViewToDiagnose(ctx, view)
+	s.diagnoseChangedView(modCtx, view, modID, nil, FromPolicyPackChange)
+}
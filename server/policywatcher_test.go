@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyWatcher(t *testing.T) {
+	root := t.TempDir()
+	pack := filepath.Join(root, "policies")
+	config := filepath.Join(root, "config", "policy-config.json")
+	for _, dir := range []string{pack, filepath.Join(pack, "node_modules"), filepath.Dir(config)} {
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+
+	changes := make(chan struct{}, 10)
+	w := newPolicyWatcher(context.Background(), func() { changes <- struct{}{} })
+	t.Cleanup(w.close)
+	require.NoError(t, w.watch([]string{pack}, []string{config, ""}))
+
+	expectChange := func(changed bool, msg string) {
+		t.Helper()
+		select {
+		case <-changes:
+			require.True(t, changed, msg)
+		case <-time.After(policyWatchDelay + time.Second):
+			require.False(t, changed, msg)
+		}
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(pack, "index.ts"), []byte("policy"), 0o644))
+	expectChange(true, "file in pack")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(pack, "lib"), 0o755))
+	expectChange(true, "new directory in pack")
+	require.NoError(t, os.WriteFile(filepath.Join(pack, "lib", "rules.ts"), []byte("rule"), 0o644))
+	expectChange(true, "file in new directory")
+
+	require.NoError(t, os.WriteFile(filepath.Join(pack, "node_modules", "dep.js"), nil, 0o644))
+	expectChange(false, "dependency of pack")
+
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(config), "other.json"), nil, 0o644))
+	expectChange(false, "file next to config")
+	require.NoError(t, os.WriteFile(config, []byte("{}"), 0o644))
+	expectChange(true, "config")
+
+	require.NoError(t, w.watch(nil, nil))
+	require.NoError(t, os.WriteFile(filepath.Join(pack, "index.ts"), []byte("unwatched"), 0o644))
+	expectChange(false, "no longer watched")
+}
@@ -59,10 +59,18 @@ func (wd *WorkDone) doCancel() {
 	wd.cancelMu.Lock()
 	defer wd.cancelMu.Unlock()
 	if !wd.cancelled {
+		wd.cancelled = true
 		wd.cancel()
 	}
 }
 
+// isCancelled reports whether the client cancelled the work.
+func (wd *WorkDone) isCancelled() bool {
+	wd.cancelMu.Lock()
+	defer wd.cancelMu.Unlock()
+	return wd.cancelled
+}
+
 func (t *Tracker) Start(ctx context.Context, title, message string, token lsp.ProgressToken, cancel func()) *WorkDone {
 	ctx = xcontext.Detach(ctx)
 	wd := &WorkDone{
@@ -115,6 +123,29 @@ func (t *Tracker) Start(ctx context.Context, title, message string, token lsp.Pr
 	return wd
 }
 
+// Report reports an update on the progress of the work to the client, with
+// the percentage of the work done if it is known, or 0. It is not reported
+// if the client doesn't support progress reporting, to avoid showing a
+// message for every update.
+func (wd *WorkDone) Report(ctx context.Context, message string, percentage float64) {
+	ctx = xcontext.Detach(ctx) // progress messages should not be cancelled
+	if wd == nil || wd.err != nil || wd.token == nil {
+		return
+	}
+	err := wd.client.ProgressReport(ctx, &lsp.WorkDoneProgressReportParams{
+		Token: wd.token,
+		Value: lsp.WorkDoneProgressReportValue{
+			Kind:        lsp.Report,
+			Message:     message,
+			Cancellable: wd.cancel != nil,
+			Percentage:  int(percentage),
+		},
+	})
+	if err != nil {
+		debug.LogError(ctx, "error reporting progress", err)
+	}
+}
+
 // End reports a workdone completion back to the client.
 func (wd *WorkDone) End(ctx context.Context, message string) {
 	ctx = xcontext.Detach(ctx) // progress messages should not be cancelled
@@ -149,6 +180,8 @@ func (wd *WorkDone) End(ctx context.Context, message string) {
 	}
 }
 
+// Cancel cancels the work in progress with the given token, at the request
+// of the client.
 func (t *Tracker) Cancel(token lsp.ProgressToken) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkDoneProgressCancel(t *testing.T) {
+	ctx := context.Background()
+	client := newRecordingClient()
+	s := &server{progress: NewTracker(client)}
+	s.progress.SetSupportsWorkDoneProgress(true)
+
+	previewCtx, cancel := context.WithCancel(ctx)
+	work := s.progress.Start(ctx, "Pulumi", "Running preview...", nil, cancel)
+	require.True(t, client.begun[0].Value.Cancellable)
+	token := client.begun[0].Token
+
+	require.NoError(t, s.WorkDoneProgressCancel(ctx, &lsp.WorkDoneProgressCancelParams{Token: token}))
+	require.ErrorIs(t, previewCtx.Err(), context.Canceled)
+	require.True(t, work.isCancelled())
+
+	// the work is no longer in progress once it ended
+	work.End(ctx, "Preview cancelled.")
+	require.Equal(t, "Preview cancelled.", client.ended[0].Value.Message)
+	require.Error(t, s.WorkDoneProgressCancel(ctx, &lsp.WorkDoneProgressCancelParams{Token: token}))
+
+	// work started without a cancel func can't be cancelled
+	other := s.progress.Start(ctx, "Pulumi", "Calculating initial diagnostics...", nil, nil)
+	require.False(t, client.begun[1].Value.Cancellable)
+	require.Error(t, s.WorkDoneProgressCancel(ctx, &lsp.WorkDoneProgressCancelParams{Token: client.begun[1].Token}))
+	require.False(t, other.isCancelled())
+}
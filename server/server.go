@@ -17,9 +17,6 @@ import (
 	"github.com/corymhall/pulumilsp/lsp"
 	"github.com/corymhall/pulumilsp/parser"
 	"github.com/corymhall/pulumilsp/xcontext"
-	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
-	tree_sitter "github.com/tree-sitter/go-tree-sitter"
-	tree_sitter_typescript "github.com/tree-sitter/tree-sitter-typescript/bindings/go"
 )
 
 var viewIndex int64
@@ -27,36 +24,64 @@ var viewIndex int64
 // New creates an LSP server and binds it to handle incoming client
 // messages on the supplied stream.
 func New(client lsp.Client) lsp.Server {
-	const concurrentAnalyses = 1
-	napper, err := parser.NewResourceNapper(tree_sitter.NewLanguage(tree_sitter_typescript.LanguageTypescript()))
-	contract.AssertNoErrorf(err, "failed to create resource napper: %v", err)
 	// If this assignment fails to compile after a protocol
 	// upgrade, it means that one or more new methods need new
 	// stub declarations in unimplemented.go.
 	return &server{
 		client:          client,
-		napper:          napper,
+		parsers:         parser.NewPool(),
 		diagnostics:     make(map[lsp.DocumentURI]*fileDiagnostics),
-		diagnosticsSema: make(chan unit, concurrentAnalyses),
+		criticalErrors:  make(map[*View]*WorkDone),
+		cancelDiagnoses: make(map[*View]func()),
 		progress:        NewTracker(client),
 		aiClient:        ai.NewClient(),
+		initOptions:     defaultSettings(),
+		defaults:        defaultSettings(),
 	}
 }
+
+// GetCapturesFromURI returns the resources declared in the file at uri, as
+// seen by the view of the project that contains it. Files outside of any
+// project, e.g. libraries shared between projects, are read from disk.
+// The parse trees of open documents are cached until they are closed, other
+// files are parsed from scratch.
 func (s *server) GetCapturesFromURI(ctx context.Context, uri lsp.DocumentURI) ([]parser.CaptureInfo, error) {
-	snapshot, release, err := s.view.Snapshot()
-	defer release()
+	var handle file.Handle
+	var err error
+	if view := s.viewOf(uri); view != nil {
+		snapshot, release, snapErr := view.Snapshot()
+		if snapErr != nil {
+			return nil, snapErr
+		}
+		defer release()
+		handle, err = snapshot.ReadFile(ctx, uri)
+	} else {
+		handle, err = ReadFile(ctx, uri)
+	}
 	if err != nil {
 		return nil, err
 	}
-	handle, err := snapshot.ReadFile(ctx, uri)
+	contents, err := handle.Content()
 	if err != nil {
 		return nil, err
 	}
-	contents, err := handle.Content()
+	if file.KindForURI(uri) == file.YAML {
+		return parser.YAMLResourceLocator{}.GetCapturesFromFile(contents)
+	}
+	lang, err := napperLanguage(uri)
 	if err != nil {
 		return nil, err
 	}
-	return s.napper.GetCapturesFromFile(contents)
+	napper, err := s.parsers.Checkout(lang)
+	if err != nil {
+		return nil, err
+	}
+	defer s.parsers.Return(napper)
+	if _, ok := handle.(*overlay); !ok {
+		// nothing would drop the tree of a file that isn't open
+		return napper.GetCapturesFromFile(contents)
+	}
+	return napper.GetCapturesFromDocument(string(uri), handle.Hash(), contents)
 }
 
 type serverState int
@@ -89,20 +114,26 @@ type server struct {
 	stateMu  sync.Mutex
 	aiClient *ai.Client
 	state    serverState
-	// Map of file names to their contents
-	rootURI lsp.DocumentURI
-
-	// view is the view associated with this server
-	view *View
+	// folders are the workspace folders opened by the client, which are
+	// searched for Pulumi projects.
+	foldersMu sync.Mutex
+	folders   []lsp.DocumentURI
+	// updateViewsMu serializes updateViews. It is held while views are
+	// initialized, so it must not be taken when handling requests.
+	updateViewsMu sync.Mutex
+
+	viewMu sync.Mutex
+	// views holds a view per Pulumi project in the workspace folders.
+	views []*View
 
 	// snapshots is a counting semaphore that records the number
 	// of unreleased snapshots associated with this session.
 	// Shutdown waits for it to fall to zero.
 	snapshotWG sync.WaitGroup
 
-	// napper is the parser used to extract resource information from
-	// files.
-	napper *parser.ResourceNapper
+	// parsers hands out the parsers used to extract resource information
+	// from files.
+	parsers *parser.Pool
 
 	// progress is the progress tracker used to report progress
 	// to the client.
@@ -110,71 +141,169 @@ type server struct {
 
 	diagnosticsMu sync.Mutex // guards map and its values
 	diagnostics   map[lsp.DocumentURI]*fileDiagnostics
-	// diagnosticsSema limits the concurrency of diagnostics runs, which can be
-	// expensive.
-	diagnosticsSema chan unit
 
-	criticalErrorStatusMu sync.Mutex
-	criticalErrorStatus   *WorkDone
-
-	modificationMu        sync.Mutex
-	cancelPrevDiagnostics func()
-	lastModificationID    uint64 // incrementing clock
+	criticalErrorsMu sync.Mutex
+	criticalErrors   map[*View]*WorkDone // the error loading each view, if any
+
+	settingsMu sync.Mutex
+	// initOptions are the settings from the initialization options. Settings
+	// pushed by clients that don't support workspace/configuration override
+	// them.
+	initOptions *Settings
+	// defaults are the settings from the initialization options, or the
+	// settings pushed by clients that don't support workspace/configuration.
+	defaults *Settings
+	// supportsConfiguration is set if the client supports
+	// workspace/configuration requests.
+	supportsConfiguration bool
+	// viewSettingsMu serializes changes to the settings of views, which
+	// derive the new settings from the current ones.
+	viewSettingsMu sync.Mutex
+
+	modificationMu     sync.Mutex
+	cancelDiagnoses    map[*View]func() // cancels the last diagnosis of each view
+	lastModificationID uint64           // incrementing clock
 }
 
-// Initialize the view for the server.
-// This only needs to be done once, since there is only one view per session
-// TODO: add stuff here that needs to happen during initialization
-func (s *server) initializeView(ctx context.Context) {
-	ctx, done := debug.Start(ctx, "server.initializeView")
+// updateViews creates a view for every Pulumi project in the workspace
+// folders that doesn't have one yet, and calculates its initial diagnostics.
+// Views of projects that are no longer in any folder are removed.
+func (s *server) updateViews(ctx context.Context) {
+	ctx, done := debug.Start(ctx, "server.updateViews")
 	defer done()
-	if s.view == nil {
-		_, snapshot, release, err := s.NewView(ctx, s.rootURI)
+	// views are created one update at a time, but the folders can change
+	// while views are initialized
+	s.updateViewsMu.Lock()
+	defer s.updateViewsMu.Unlock()
+	s.foldersMu.Lock()
+	folders := slices.Clone(s.folders)
+	s.foldersMu.Unlock()
+
+	var roots []lsp.DocumentURI
+	for _, folder := range folders {
+		dirs, err := findProjects(folder.Path())
 		if err != nil {
-			debug.LogError(ctx, "error creating view", err)
+			debug.LogError(ctx, fmt.Sprintf("error searching %s for Pulumi projects", folder), err)
+			continue
+		}
+		for _, dir := range dirs {
+			if root := lsp.URIFromPath(dir); !slices.Contains(roots, root) {
+				roots = append(roots, root)
+			}
+		}
+	}
+
+	s.viewMu.Lock()
+	var removed []*View
+	s.views = slices.DeleteFunc(s.views, func(v *View) bool {
+		if slices.Contains(roots, v.root) {
+			return false
+		}
+		removed = append(removed, v)
+		return true
+	})
+	existing := make(map[lsp.DocumentURI]bool)
+	for _, v := range s.views {
+		existing[v.root] = true
+	}
+	s.viewMu.Unlock()
+
+	for _, view := range removed {
+		s.removeView(ctx, view)
+	}
+	for _, root := range roots {
+		if !existing[root] {
+			s.initializeView(ctx, root)
 		}
-		ctx, _ = debug.With(ctx, "snapshotSequenceID", snapshot.sequenceID)
-
-		var nsnapshots sync.WaitGroup
-		initialized := make(chan struct{})
-		nsnapshots.Add(1)
-		work := s.progress.Start(ctx, "Pulumi", "Calculating initial diagnostics...", nil, nil)
-		go func() {
-			snapshot.AwaitInitialized(ctx)
-			nsnapshots.Done()
-			close(initialized)
-		}()
-
-		go func() {
-			<-initialized
-			s.diagnoseSnapshot(ctx, snapshot, nil, 0)
-			release()
-			work.End(ctx, "Done.")
-		}()
-
-		// wait for snapshots to be initialized, but don't wait for diagnosis to finish
-		nsnapshots.Wait()
 	}
 }
 
-func (s *server) NewView(ctx context.Context, root lsp.DocumentURI) (*View, *Snapshot, func(), error) {
-	contract.Assertf(s.view == nil, "NewView called when view already exists")
+// removeView shuts down a view that was removed from s.views, and clears
+// the diagnostics it published.
+func (s *server) removeView(ctx context.Context, view *View) {
+	s.modificationMu.Lock()
+	if cancel, ok := s.cancelDiagnoses[view]; ok {
+		cancel()
+		delete(s.cancelDiagnoses, view)
+	}
+	s.modificationMu.Unlock()
+
+	view.shutdown()
+	view.policies.close()
+	s.clearViewDiagnostics(ctx, view)
+	s.updateCriticalErrorStatus(ctx, view, nil)
+	// a preview of the shadow workspace may still be running, don't wait
+	// for it
+	go view.shadow.close()
+}
 
+// initializeView creates the view for the project at root. It waits for the
+// view to be initialized, but not for its initial diagnostics.
+func (s *server) initializeView(ctx context.Context, root lsp.DocumentURI) {
+	_, snapshot, release, err := s.NewView(ctx, root, s.settingsFor(ctx, &root))
+	if err != nil {
+		debug.LogError(ctx, "error creating view", err)
+		return
+	}
+	ctx, _ = debug.With(ctx, "root", root, "snapshotSequenceID", snapshot.sequenceID)
+
+	var nsnapshots sync.WaitGroup
+	initialized := make(chan struct{})
+	nsnapshots.Add(1)
+	// like any other preview, the initial one can be cancelled from the
+	// editor
+	diagnoseCtx, cancel := context.WithCancel(ctx)
+	work := s.progress.Start(diagnoseCtx, "Pulumi", fmt.Sprintf("Calculating initial diagnostics for %s...", filepath.Base(root.Path())), nil, cancel)
+	go func() {
+		snapshot.AwaitInitialized(ctx)
+		nsnapshots.Done()
+		close(initialized)
+	}()
+
+	go func() {
+		defer cancel()
+		<-initialized
+		s.diagnoseSnapshot(diagnoseCtx, snapshot, nil, FromInitialWorkspaceLoad, 0, work)
+		release()
+		if work.isCancelled() {
+			work.End(ctx, "Preview cancelled.")
+			return
+		}
+		work.End(ctx, "Done.")
+	}()
+
+	// wait for snapshots to be initialized, but don't wait for diagnosis to finish
+	nsnapshots.Wait()
+}
+
+func (s *server) NewView(ctx context.Context, root lsp.DocumentURI, settings *Settings) (*View, *Snapshot, func(), error) {
 	dir := root.Path()
-	pulumiyaml := filepath.Join(dir, "Pulumi.yaml")
+	pulumiyaml, _ := projectFile(dir)
 	def := &viewDefinition{
 		root:       root,
 		pulumiyaml: lsp.URIFromPath(pulumiyaml),
+		settings:   settings,
 	}
 
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+	if slices.ContainsFunc(s.views, func(v *View) bool { return v.root == root }) {
+		return nil, nil, nil, fmt.Errorf("view for %s already exists", root)
+	}
 	view, snapshot, release := s.createView(ctx, def)
-	s.view = view
+	s.views = append(s.views, view)
 	return view, snapshot, release, nil
 }
 
-func (s *server) createView(ctx context.Context, def *viewDefinition) (*View, *Snapshot, func()) {
-	contract.Assertf(s.view == nil, "createView called when view already exists")
+// viewOf returns the view of the project containing uri, or nil if it is not
+// part of any project.
+func (s *server) viewOf(uri lsp.DocumentURI) *View {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+	return nearestView(s.views, uri)
+}
 
+func (s *server) createView(ctx context.Context, def *viewDefinition) (*View, *Snapshot, func()) {
 	index := atomic.AddInt64(&viewIndex, 1)
 	// create a background context for the view
 	baseCtx := xcontext.Detach(ctx)
@@ -184,8 +313,15 @@ func (s *server) createView(ctx context.Context, def *viewDefinition) (*View, *S
 		baseCtx:              baseCtx,
 		initialWorkspaceLoad: make(chan struct{}),
 		initializationSema:   make(chan struct{}, 1),
+		diagnosticsSema:      make(chan unit, 1),
 		viewDefinition:       def,
+		configured:           def.settings,
+		shadow:               newShadowWorkspace(def.root),
 	}
+	v.policies = newPolicyWatcher(baseCtx, func() {
+		s.didChangePolicyPacks(baseCtx, v)
+	})
+	s.watchPolicyPacks(ctx, v, def.settings)
 	s.snapshotWG.Add(1)
 	v.snapshot = &Snapshot{
 		view:              v,
@@ -193,6 +329,7 @@ func (s *server) createView(ctx context.Context, def *viewDefinition) (*View, *S
 		cancel:            cancel,
 		policyDiagnostics: make(map[lsp.DocumentURI]any),
 		files:             make(fileMap),
+		settings:          def.settings,
 		refcount:          1,
 		done:              s.snapshotWG.Done,
 	}
@@ -209,33 +346,33 @@ func (s *server) createView(ctx context.Context, def *viewDefinition) (*View, *S
 	return v, snapshot, snapshot.Acquire()
 }
 
-func (s *server) updateCriticalErrorStatus(ctx context.Context, snapshot *Snapshot, err *InitializationError) {
-	s.criticalErrorStatusMu.Lock()
-	defer s.criticalErrorStatusMu.Unlock()
+func (s *server) updateCriticalErrorStatus(ctx context.Context, view *View, err *InitializationError) {
+	s.criticalErrorsMu.Lock()
+	defer s.criticalErrorsMu.Unlock()
 
 	var errMsg string
 	if err != nil {
-		errMsg = strings.ReplaceAll(err.MainError.Error(), "\n", " ")
+		errMsg = fmt.Sprintf("%s: %s", view.root.Path(), strings.ReplaceAll(err.MainError.Error(), "\n", " "))
 	}
 
-	if s.criticalErrorStatus == nil {
+	status, ok := s.criticalErrors[view]
+	if !ok {
 		if errMsg != "" {
-			s.criticalErrorStatus = s.progress.Start(ctx, "Error loading workspace", errMsg, nil, nil)
+			s.criticalErrors[view] = s.progress.Start(ctx, "Error loading workspace", errMsg, nil, nil)
 		}
 		return
 	}
 
 	// if an error is already present, update it or mark it as resolved
 	if errMsg == "" {
-		s.criticalErrorStatus.End(ctx, "Done.")
-		s.criticalErrorStatus = nil
+		status.End(ctx, "Done.")
+		delete(s.criticalErrors, view)
 	}
 }
 
-func (s *server) invalidateViewLocked(ctx context.Context, changed StateChange) (*Snapshot, func()) {
+func (s *server) invalidateViewLocked(ctx context.Context, view *View, changed StateChange) (*Snapshot, func()) {
 
 	ctx = xcontext.Detach(ctx)
-	view := s.view
 	view.snapshotMu.Lock()
 	defer view.snapshotMu.Unlock()
 	prevSnapshot := view.snapshot
@@ -268,10 +405,19 @@ func (s *server) Shutdown(ctx context.Context) error {
 	if s.state != serverShutDown {
 		// drop all the active views
 		s.state = serverShutDown
-		s.view.shutdown()
-		s.view = nil
+		s.viewMu.Lock()
+		views := s.views
+		s.views = nil
+		s.viewMu.Unlock()
+		for _, view := range views {
+			view.shutdown()
+			view.policies.close()
+		}
 		s.snapshotWG.Wait() // wait for all work on associated snapshots to finish
-		s.napper.Close()
+		for _, view := range views {
+			view.shadow.close()
+		}
+		s.parsers.Close()
 	}
 	return nil
 }
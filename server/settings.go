@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TriggerMode controls when previews run.
+type TriggerMode string
+
+const (
+	// TriggerOnSave runs a preview when a file is saved.
+	TriggerOnSave TriggerMode = "onSave"
+	// TriggerOnChange also runs previews of unsaved changes, once no changes
+	// have been made for the preview delay.
+	TriggerOnChange TriggerMode = "onChange"
+)
+
+// AIProvider is a provider of fixes for policy violations.
+type AIProvider string
+
+const (
+	AIProviderCopilot AIProvider = "copilot"
+	AIProviderOpenAI  AIProvider = "openai"
+)
+
+// Settings are the user settings of the server. They are read from the
+// "pulumilsp" section of the client's configuration for each project, with
+// the initialization options as defaults.
+type Settings struct {
+	// LogLevel is one of "debug", "info", "warn" or "error".
+	LogLevel string `json:"logLevel,omitempty"`
+	// Stack is the stack to preview. If empty, the stack that is selected in
+	// the project is previewed.
+	Stack string `json:"stack,omitempty"`
+	// PolicyPacks are the paths of policy packs to run previews with, in
+	// addition to those required for the stack. Relative paths are relative
+	// to the project directory.
+	PolicyPacks []string `json:"policyPacks,omitempty"`
+	// PolicyPackConfigs are the paths of the JSON config files of policy
+	// packs, by the path of the pack as it is written in PolicyPacks. Packs
+	// without a config file use their default config.
+	PolicyPackConfigs map[string]string `json:"policyPackConfigs,omitempty"`
+	// Trigger controls when previews run.
+	Trigger TriggerMode `json:"trigger,omitempty"`
+	// PreviewDelay is how long to wait after the last change before
+	// previewing unsaved changes, e.g. "500ms".
+	PreviewDelay string `json:"previewDelay,omitempty"`
+	// EnabledPolicies are the names of the policies whose violations are
+	// reported. If empty, violations of all policies are reported.
+	EnabledPolicies []string `json:"enabledPolicies,omitempty"`
+	// AIProvider is the provider used to fix violations. If empty, it is
+	// chosen based on the violation.
+	AIProvider AIProvider `json:"aiProvider,omitempty"`
+}
+
+func defaultSettings() *Settings {
+	return &Settings{
+		LogLevel:     "info",
+		Trigger:      TriggerOnSave,
+		PreviewDelay: "1s",
+	}
+}
+
+// parseSettings returns defaults overridden by the settings in raw.
+func parseSettings(defaults *Settings, raw []byte) (*Settings, error) {
+	settings := defaults.clone()
+	if err := json.Unmarshal(raw, settings); err != nil {
+		return nil, fmt.Errorf("error unmarshalling settings: %w", err)
+	}
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (s *Settings) clone() *Settings {
+	c := *s
+	c.PolicyPacks = slices.Clone(s.PolicyPacks)
+	c.PolicyPackConfigs = maps.Clone(s.PolicyPackConfigs)
+	c.EnabledPolicies = slices.Clone(s.EnabledPolicies)
+	return &c
+}
+
+func (s *Settings) validate() error {
+	if _, err := parseLogLevel(s.LogLevel); err != nil {
+		return err
+	}
+	switch s.Trigger {
+	case TriggerOnSave, TriggerOnChange:
+	default:
+		return fmt.Errorf("invalid trigger %q, expected %q or %q", s.Trigger, TriggerOnSave, TriggerOnChange)
+	}
+	if _, err := time.ParseDuration(s.PreviewDelay); err != nil {
+		return fmt.Errorf("invalid previewDelay %q: %w", s.PreviewDelay, err)
+	}
+	for pack := range s.PolicyPackConfigs {
+		if !slices.Contains(s.PolicyPacks, pack) {
+			return fmt.Errorf("policyPackConfigs has a config for %q, which is not in policyPacks", pack)
+		}
+	}
+	switch s.AIProvider {
+	case "", AIProviderCopilot, AIProviderOpenAI:
+	default:
+		return fmt.Errorf("invalid aiProvider %q, expected %q or %q", s.AIProvider, AIProviderCopilot, AIProviderOpenAI)
+	}
+	return nil
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid logLevel %q", level)
+}
+
+// logLevel returns the log level. Settings are validated when they are
+// parsed, so it is always valid.
+func (s *Settings) logLevel() slog.Level {
+	level, _ := parseLogLevel(s.LogLevel)
+	return level
+}
+
+// previewDelay returns the preview delay. Settings are validated when they
+// are parsed, so it is always valid.
+func (s *Settings) previewDelay() time.Duration {
+	delay, _ := time.ParseDuration(s.PreviewDelay)
+	return delay
+}
+
+// policyPackPaths returns the paths of the policy packs, relative paths
+// resolved against the project directory root.
+func (s *Settings) policyPackPaths(root string) []string {
+	paths := make([]string, 0, len(s.PolicyPacks))
+	for _, path := range s.PolicyPacks {
+		paths = append(paths, resolvePath(root, path))
+	}
+	return paths
+}
+
+// policyPackConfigPaths returns the paths of the config files of the policy
+// packs, in the order of policyPackPaths, relative paths resolved against the
+// project directory root. Packs without a config file have an empty path. It
+// returns nil if no pack has a config file.
+func (s *Settings) policyPackConfigPaths(root string) []string {
+	if len(s.PolicyPackConfigs) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(s.PolicyPacks))
+	for _, pack := range s.PolicyPacks {
+		path := s.PolicyPackConfigs[pack]
+		if path != "" {
+			path = resolvePath(root, path)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func resolvePath(root, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// policyEnabled reports whether violations of the named policy are
+// reported.
+func (s *Settings) policyEnabled(name string) bool {
+	return len(s.EnabledPolicies) == 0 || slices.Contains(s.EnabledPolicies, name)
+}
+
+// affectsDiagnostics reports whether diagnostics computed with s may differ
+// from those computed with other.
+func (s *Settings) affectsDiagnostics(other *Settings) bool {
+	return s.Stack != other.Stack ||
+		!slices.Equal(s.PolicyPacks, other.PolicyPacks) ||
+		!maps.Equal(s.PolicyPackConfigs, other.PolicyPackConfigs) ||
+		!slices.Equal(s.EnabledPolicies, other.EnabledPolicies)
+}
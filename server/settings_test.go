@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSettings(t *testing.T) {
+	defaults := &Settings{LogLevel: "warn", Trigger: TriggerOnSave, PreviewDelay: "1s", Stack: "dev"}
+
+	settings, err := parseSettings(defaults, []byte(`{"trigger":"onChange","policyPacks":["policies"],"enabledPolicies":["s3-no-public-read"]}`))
+	require.NoError(t, err)
+	require.Equal(t, &Settings{
+		LogLevel:        "warn",
+		Stack:           "dev",
+		Trigger:         TriggerOnChange,
+		PreviewDelay:    "1s",
+		PolicyPacks:     []string{"policies"},
+		EnabledPolicies: []string{"s3-no-public-read"},
+	}, settings)
+	require.Equal(t, "warn", defaults.LogLevel, "defaults are not modified")
+	require.Equal(t, []string{"/repo/infra/policies", "/opt/policies"}, (&Settings{PolicyPacks: []string{"policies", "/opt/policies"}}).policyPackPaths("/repo/infra"))
+	require.True(t, settings.policyEnabled("s3-no-public-read"))
+	require.False(t, settings.policyEnabled("s3-versioning"))
+	require.True(t, defaults.policyEnabled("s3-versioning"))
+
+	settings, err = parseSettings(defaults, []byte(`{"policyPacks":["policies","/opt/policies"],"policyPackConfigs":{"/opt/policies":"config.json"}}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"", "/repo/infra/config.json"}, settings.policyPackConfigPaths("/repo/infra"))
+	require.Nil(t, defaults.policyPackConfigPaths("/repo/infra"))
+
+	for _, raw := range []string{
+		`{"logLevel":"verbose"}`,
+		`{"trigger":"onType"}`,
+		`{"previewDelay":"soon"}`,
+		`{"aiProvider":"gemini"}`,
+		`{"policyPacks":"policies"}`,
+		`{"policyPackConfigs":{"policies":"config.json"}}`,
+	} {
+		_, err := parseSettings(defaults, []byte(raw))
+		require.Error(t, err, raw)
+	}
+}
+
+func TestSettingsAffectsDiagnostics(t *testing.T) {
+	settings := &Settings{Stack: "dev", PolicyPacks: []string{"policies"}}
+
+	require.False(t, settings.affectsDiagnostics(&Settings{Stack: "dev", PolicyPacks: []string{"policies"}, LogLevel: "debug"}))
+	require.True(t, settings.affectsDiagnostics(&Settings{Stack: "prod", PolicyPacks: []string{"policies"}}))
+	require.True(t, settings.affectsDiagnostics(&Settings{Stack: "dev"}))
+	require.True(t, settings.affectsDiagnostics(&Settings{Stack: "dev", PolicyPacks: []string{"policies"}, EnabledPolicies: []string{"s3-no-public-read"}}))
+}
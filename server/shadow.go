@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/corymhall/pulumilsp/file"
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/corymhall/pulumilsp/pulumicommand"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+)
+
+// linkedDirs are directories that are linked into the shadow workspace rather
+// than copied, since they can be large and are not edited by the user.
+var linkedDirs = map[string]bool{
+	"node_modules": true,
+	"venv":         true,
+	".venv":        true,
+}
+
+// skippedDirs are directories that are left out of the shadow workspace.
+var skippedDirs = map[string]bool{
+	".git": true,
+}
+
+// A shadowWorkspace is a copy of the project that the unsaved contents of
+// open files are written to, so that the program can be previewed as it is
+// in the editor. It is created on first use and kept in sync with the
+// project on every run.
+type shadowWorkspace struct {
+	root lsp.DocumentURI
+
+	// mu serializes runs, since the copy must not change while a preview of
+	// it is running.
+	mu     sync.Mutex
+	closed bool
+	dir    string               // the copy of root, created on first use
+	hashes map[string]file.Hash // hashes of the files copied to dir, by relative path
+	runner *pulumicommand.Runner
+}
+
+func newShadowWorkspace(root lsp.DocumentURI) *shadowWorkspace {
+	return &shadowWorkspace{
+		root:   root,
+		hashes: make(map[string]file.Hash),
+	}
+}
+
+// run previews the shadow workspace with the given overlays, using the same
+// stack as runner. Source positions in the result refer to the files in the
+// project rather than their copies.
+func (w *shadowWorkspace) run(ctx context.Context, runner *pulumicommand.Runner, overlays map[lsp.DocumentURI][]byte, opts ...optpreview.Option) (*pulumicommand.Result, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil, errors.New("shadow workspace is closed")
+	}
+	if err := w.sync(overlays); err != nil {
+		return nil, fmt.Errorf("error syncing shadow workspace: %w", err)
+	}
+	if w.runner == nil || w.runner.StackName() != runner.StackName() {
+		workspace, err := auto.NewLocalWorkspace(ctx, auto.WorkDir(w.dir))
+		if err != nil {
+			return nil, fmt.Errorf("error creating shadow workspace: %w", err)
+		}
+		stack, err := auto.SelectStack(ctx, runner.StackName(), workspace)
+		if err != nil {
+			return nil, fmt.Errorf("error selecting stack: %w", err)
+		}
+		w.runner = pulumicommand.New(stack)
+	}
+
+	result, err := w.runner.Run(ctx, opts...)
+	if result == nil {
+		return nil, err
+	}
+	for _, info := range result.Resources {
+		if info.SourcePosition != nil {
+			info.SourcePosition.Uri = w.projectURI(info.SourcePosition.Uri)
+		}
+	}
+	for _, engineErr := range result.Errors {
+		if engineErr.SourcePosition != nil {
+			engineErr.SourcePosition.Uri = w.projectURI(engineErr.SourcePosition.Uri)
+		}
+		for i := range engineErr.Frames {
+			engineErr.Frames[i].URI = w.projectURI(engineErr.Frames[i].URI)
+		}
+	}
+	return result, err
+}
+
+// sync updates the copy to match the project, with the content of overlays
+// in place of the files on disk. Only files that changed since the last sync
+// are written.
+func (w *shadowWorkspace) sync(overlays map[lsp.DocumentURI][]byte) error {
+	if w.dir == "" {
+		dir, err := os.MkdirTemp("", "pulumilsp-shadow-")
+		if err != nil {
+			return err
+		}
+		// resolve e.g. /tmp -> /private/tmp, so that it matches the paths
+		// the language runtimes report
+		if w.dir, err = filepath.EvalSymlinks(dir); err != nil {
+			return err
+		}
+	}
+
+	root := w.root.Path()
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(w.dir, rel)
+		switch {
+		case d.IsDir() && rel == ".":
+			return nil
+		case d.IsDir() && skippedDirs[d.Name()]:
+			return filepath.SkipDir
+		case d.IsDir() && linkedDirs[d.Name()]:
+			if _, err := os.Lstat(target); err == nil {
+				return filepath.SkipDir
+			}
+			if err := os.Symlink(path, target); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			if _, err := os.Lstat(target); err == nil {
+				return nil
+			}
+			return os.Symlink(path, target)
+		case !d.Type().IsRegular():
+			return nil
+		}
+
+		seen[rel] = true
+		content, ok := overlays[lsp.URIFromPath(path)]
+		if !ok {
+			if content, err = os.ReadFile(path); err != nil {
+				return err
+			}
+		}
+		hash := file.HashOf(content)
+		if old, ok := w.hashes[rel]; ok && old == hash {
+			return nil
+		}
+		if err := os.WriteFile(target, content, 0o644); err != nil {
+			return err
+		}
+		w.hashes[rel] = hash
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// remove the copies of files that were deleted from the project
+	for rel := range w.hashes {
+		if seen[rel] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(w.dir, rel)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(w.hashes, rel)
+	}
+	return nil
+}
+
+// projectURI maps the URI of a file in the copy to the same file in the
+// project. Other URIs are returned as is.
+func (w *shadowWorkspace) projectURI(uri string) string {
+	prefix := string(lsp.URIFromPath(w.dir)) + "/"
+	if rest, ok := strings.CutPrefix(uri, prefix); ok {
+		return strings.TrimSuffix(string(w.root), "/") + "/" + rest
+	}
+	return uri
+}
+
+// close removes the copy. It waits for a running preview to finish.
+func (w *shadowWorkspace) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.dir != "" {
+		os.RemoveAll(w.dir)
+	}
+}
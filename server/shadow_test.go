@@ -0,0 +1,59 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadowWorkspaceSync(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel, content string) {
+		path := filepath.Join(root, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	write("Pulumi.yaml", "name: test\nruntime: nodejs\n")
+	write("index.ts", "saved")
+	write("lib/bucket.ts", "bucket")
+	write("node_modules/pkg/index.js", "dep")
+	write(".git/HEAD", "ref")
+
+	w := newShadowWorkspace(lsp.URIFromPath(root))
+	defer w.close()
+
+	index := lsp.URIFromPath(filepath.Join(root, "index.ts"))
+	require.NoError(t, w.sync(map[lsp.DocumentURI][]byte{index: []byte("unsaved")}))
+
+	read := func(rel string) string {
+		content, err := os.ReadFile(filepath.Join(w.dir, rel))
+		require.NoError(t, err)
+		return string(content)
+	}
+	require.Equal(t, "unsaved", read("index.ts"))
+	require.Equal(t, "bucket", read("lib/bucket.ts"))
+	require.Equal(t, "dep", read("node_modules/pkg/index.js"))
+	info, err := os.Lstat(filepath.Join(w.dir, "node_modules"))
+	require.NoError(t, err)
+	require.NotZero(t, info.Mode()&os.ModeSymlink, "node_modules should be linked")
+	require.NoFileExists(t, filepath.Join(w.dir, ".git", "HEAD"))
+
+	// without the overlay the saved content is restored, deleted files are removed
+	require.NoError(t, os.Remove(filepath.Join(root, "lib", "bucket.ts")))
+	require.NoError(t, w.sync(nil))
+	require.Equal(t, "saved", read("index.ts"))
+	require.NoFileExists(t, filepath.Join(w.dir, "lib", "bucket.ts"))
+}
+
+func TestShadowWorkspaceProjectURI(t *testing.T) {
+	w := &shadowWorkspace{
+		root: "file:///home/me/project",
+		dir:  "/tmp/pulumilsp-shadow-123",
+	}
+	require.Equal(t, "file:///home/me/project/lib/index.ts", w.projectURI("file:///tmp/pulumilsp-shadow-123/lib/index.ts"))
+	require.Equal(t, "file:///home/me/other/index.ts", w.projectURI("file:///home/me/other/index.ts"))
+	require.Equal(t, "file:///tmp/pulumilsp-shadow-1234/index.ts", w.projectURI("file:///tmp/pulumilsp-shadow-1234/index.ts"))
+}
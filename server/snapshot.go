@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"maps"
 	"sync"
@@ -43,6 +44,10 @@ type Snapshot struct {
 
 	initialErr *InitializationError
 
+	// settings are the settings of the project. If settings.Stack is empty,
+	// the stack that is currently selected in the workspace is previewed.
+	settings *Settings
+
 	// limits command concurrency
 	pulumicmdRunner *pulumicommand.Runner
 
@@ -128,15 +133,19 @@ func (s *Snapshot) initializeWorkspace(ctx context.Context) error {
 		return fmt.Errorf("error creating workspace: %w", err)
 	}
 
-	currentStack, err := workspace.Stack(ctx)
-	if err != nil {
-		return fmt.Errorf("error getting current stack: %w", err)
-	}
-	if currentStack == nil {
-		return fmt.Errorf("error getting current stack: %w", err)
+	stackName := s.settings.Stack
+	if stackName == "" {
+		currentStack, err := workspace.Stack(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting current stack: %w", err)
+		}
+		if currentStack == nil {
+			return errors.New("no stack is selected, select one with `pulumi stack select` or the pulumilsp.stack setting")
+		}
+		stackName = currentStack.Name
 	}
 
-	stack, err := auto.SelectStack(ctx, currentStack.Name, workspace)
+	stack, err := auto.SelectStack(ctx, stackName, workspace)
 	if err != nil {
 		return fmt.Errorf("error selecting stack: %w", err)
 	}
@@ -210,6 +219,19 @@ func (s *Snapshot) ReadFile(ctx context.Context, uri lsp.DocumentURI) (file.Hand
 	return lockedSnapshot{s}.ReadFile(ctx, uri)
 }
 
+// Overlays returns the content of the files that are open in the editor.
+func (s *Snapshot) Overlays() map[lsp.DocumentURI][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	overlays := make(map[lsp.DocumentURI][]byte)
+	for uri, fh := range s.files {
+		if o, ok := fh.(*overlay); ok {
+			overlays[uri] = o.content
+		}
+	}
+	return overlays
+}
+
 type lockedSnapshot struct {
 	s *Snapshot
 }
@@ -245,8 +267,18 @@ func (s *Snapshot) clone(bgCtx context.Context, changed StateChange, done func()
 		files:             s.files.clone(changedFiles),
 		view:              s.view,
 		initialized:       s.initialized,
+		settings:          s.settings,
 		policyDiagnostics: diags,
 	}
+	if changed.Settings != nil {
+		result.settings = changed.Settings
+		if changed.Settings.Stack != s.settings.Stack {
+			// the runner previews the old stack, so the new snapshot must be
+			// initialized again
+			result.pulumicmdRunner = nil
+			result.initialized = false
+		}
+	}
 
 	return result
 }
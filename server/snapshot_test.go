@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/corymhall/pulumilsp/pulumicommand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotCloneSelectStack(t *testing.T) {
+	snapshot := &Snapshot{
+		files:           make(fileMap),
+		initialized:     true,
+		pulumicmdRunner: &pulumicommand.Runner{},
+		settings:        &Settings{Stack: "dev"},
+	}
+	done := func() {}
+
+	clone := snapshot.clone(context.Background(), StateChange{Settings: &Settings{Stack: "dev", LogLevel: "debug"}}, done)
+	require.True(t, clone.initialized)
+	require.NotNil(t, clone.pulumicmdRunner)
+
+	require.Equal(t, "debug", clone.settings.LogLevel)
+
+	clone = snapshot.clone(context.Background(), StateChange{Settings: &Settings{Stack: "prod"}}, done)
+	require.Equal(t, "prod", clone.settings.Stack)
+	require.False(t, clone.initialized, "a new stack needs a new runner")
+	require.Nil(t, clone.pulumicmdRunner)
+
+	clone = clone.clone(context.Background(), StateChange{}, done)
+	require.Equal(t, "prod", clone.settings.Stack)
+}
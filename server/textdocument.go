@@ -50,46 +50,123 @@ const (
 	// FromToggleCompilerOptDetails refers to state changes resulting from toggling
 	// a package's compiler optimization details flag.
 	FromToggleCompilerOptDetails
+
+	// FromSelectStack refers to state changes resulting from the selectStack
+	// command.
+	FromSelectStack
+
+	// FromPolicyPackChange refers to changes of the files of a local policy
+	// pack or its config.
+	FromPolicyPackChange
 )
 
 func (s *server) didModifyFiles(ctx context.Context, modifications []file.Modification, cause ModificationSource) error {
 	ctx, done := debug.Start(ctx, "textdocument.didModifyFiles")
 	defer done()
+
+	// each view is invalidated and diagnosed independently with the
+	// modifications of the files in its project
+	byView := make(map[*View][]file.Modification)
+	for _, mod := range modifications {
+		s.mustPublishDiagnostics(mod.URI)
+		view := s.viewOf(mod.URI)
+		if view == nil {
+			debug.Debug.Log(ctx, "Ignoring modification of file outside of any Pulumi project", "uri", mod.URI)
+			continue
+		}
+		byView[view] = append(byView[view], mod)
+	}
+
+	for view, mods := range byView {
+		if err := s.didModifyViewFiles(ctx, view, mods, cause); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *server) didModifyViewFiles(ctx context.Context, view *View, modifications []file.Modification, cause ModificationSource) error {
 	changes := []lsp.DocumentURI{}
 	for _, mod := range modifications {
 		changes = append(changes, mod.URI)
-		s.mustPublishDiagnostics(mod.URI)
 	}
 
+	prevSnapshot, release, err := view.Snapshot()
+	if err != nil {
+		return err
+	}
 	changed := make(fileMap)
 	for _, m := range modifications {
-		fh := mustReadFile(ctx, m.URI)
-		changed[m.URI] = fh
+		changed[m.URI] = modifiedFile(ctx, prevSnapshot, m)
 	}
+	release()
 
-	snapshot, release := s.invalidateViewLocked(ctx, StateChange{Modifications: modifications, Files: changed})
+	snapshot, release := s.invalidateViewLocked(ctx, view, StateChange{Modifications: modifications, Files: changed})
 	release()
 	ctx, _ = debug.With(ctx, "snapshotSequenceID", snapshot.sequenceID)
 
-	modCtx, modID := s.updateViewsToDiagnose(ctx)
+	if !diagnosesChangesFrom(snapshot.settings, cause) {
+		// don't cancel diagnostics of earlier changes for a change we won't
+		// diagnose
+		return nil
+	}
+	modCtx, modID := s.updateViewToDiagnose(ctx, view)
 	// don't block on diagnostics
 	go func() {
-		s.diagnoseChangedView(modCtx, modID, changes, cause)
+		s.diagnoseChangedView(modCtx, view, modID, changes, cause)
 	}()
 
 	return nil
 }
 
-func (s *server) updateViewsToDiagnose(ctx context.Context) (context.Context, uint64) {
+// modifiedFile returns the handle of the file modified by m. Files that are
+// open in the editor are overlays that carry the client's version of the
+// document, other files are read from disk.
+func modifiedFile(ctx context.Context, prev *Snapshot, m file.Modification) file.Handle {
+	switch m.Action {
+	case file.Open, file.Change:
+		return newOverlay(m)
+	case file.Save:
+		// the document is still open, so keep its version, and its content
+		// unless the client sent the saved text
+		if fh, err := prev.ReadFile(ctx, m.URI); err == nil {
+			if o, ok := fh.(*overlay); ok {
+				m.Version = o.version
+				if m.Text == nil {
+					m.Text = o.content
+				}
+				return newOverlay(m)
+			}
+		}
+	}
+	return mustReadFile(ctx, m.URI)
+}
+
+// updateViewToDiagnose cancels the running diagnosis of view, if any, and
+// returns the context for the next one.
+func (s *server) updateViewToDiagnose(ctx context.Context, view *View) (context.Context, uint64) {
 	s.modificationMu.Lock()
 	defer s.modificationMu.Unlock()
-	if s.cancelPrevDiagnostics != nil {
-		s.cancelPrevDiagnostics()
+	if cancel, ok := s.cancelDiagnoses[view]; ok {
+		cancel()
 	}
 	modCtx := xcontext.Detach(ctx)
-	modCtx, s.cancelPrevDiagnostics = context.WithCancel(modCtx)
+	modCtx, s.cancelDiagnoses[view] = context.WithCancel(modCtx)
 	s.lastModificationID++
 	modID := s.lastModificationID
 	modCtx, _ = debug.With(modCtx, "modificationID", modID)
 	return modCtx, modID
 }
+
+// diagnosesChangesFrom reports whether changes from cause trigger a preview.
+// Previews normally only run on save, but can also run on every change.
+func diagnosesChangesFrom(settings *Settings, cause ModificationSource) bool {
+	switch cause {
+	case FromDidSave:
+		return true
+	case FromDidChange:
+		return settings.Trigger == TriggerOnChange
+	default:
+		return false
+	}
+}
@@ -51,9 +51,10 @@ func (s *server) ResolveCodeAction(ctx context.Context, params *lsp.CodeAction)
 
 	work := s.progress.Start(ctx, "Pulumi", "Fixing with Copilot...", nil, nil)
 	var fix string
-	if strings.Contains(params.Title, "Replication") {
+	switch s.aiProviderFor(data.URI, params.Title) {
+	case AIProviderCopilot:
 		fix, err = s.aiClient.FixWithCopilot(ctx, "pulumi", data.Text, diagnostic.Message)
-	} else {
+	default:
 		fix, err = ai.FixWithOpenAI(ctx, data.Text, diagnostic.Message)
 	}
 
@@ -71,19 +72,30 @@ func (s *server) ResolveCodeAction(ctx context.Context, params *lsp.CodeAction)
 				data.URI: []lsp.TextEdit{
 					{
 						NewText: fix,
-						Range: lsp.Range{
-							Start: lsp.Position{
-								Line:      int32(data.CaptureInfo.StartPoint.Row),
-								Character: int32(data.CaptureInfo.StartPoint.Column),
-							},
-							End: lsp.Position{
-								Line:      int32(data.CaptureInfo.EndPoint.Row),
-								Character: int32(data.CaptureInfo.EndPoint.Column),
-							},
-						},
+						Range:   captureRange(&data.CaptureInfo),
 					},
 				},
 			},
 		},
 	}, nil
 }
+
+// aiProviderFor returns the provider to fix a violation in uri with. If none
+// is configured, it is chosen based on the title of the code action.
+func (s *server) aiProviderFor(uri lsp.DocumentURI, title string) AIProvider {
+	var provider AIProvider
+	if view := s.viewOf(uri); view != nil {
+		provider = view.Settings().AIProvider
+	} else {
+		s.settingsMu.Lock()
+		provider = s.defaults.AIProvider
+		s.settingsMu.Unlock()
+	}
+	if provider != "" {
+		return provider
+	}
+	if strings.Contains(title, "Replication") {
+		return AIProviderCopilot
+	}
+	return AIProviderOpenAI
+}
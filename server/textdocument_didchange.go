@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/corymhall/pulumilsp/debug"
+	"github.com/corymhall/pulumilsp/file"
+	"github.com/corymhall/pulumilsp/lsp"
+)
+
+func (s *server) DidChange(ctx context.Context, params *lsp.DidChangeTextDocumentParams) error {
+	ctx, done := debug.Start(ctx, "DidChange", slog.String("uri", string(params.TextDocument.URI)))
+	defer done()
+	uri := params.TextDocument.URI
+	view := s.viewOf(uri)
+	if view == nil {
+		// not part of a Pulumi project, there's nothing to keep track of
+		return nil
+	}
+	text, err := s.changedText(ctx, view, uri, params.ContentChanges)
+	if err != nil {
+		return err
+	}
+	return s.didModifyFiles(ctx, []file.Modification{{
+		URI:     uri,
+		Action:  file.Change,
+		Version: int32(params.TextDocument.Version),
+		Text:    text,
+	}}, FromDidChange)
+}
+
+// changedText returns the content of the document at uri after applying
+// changes to its content in the current snapshot of view.
+func (s *server) changedText(ctx context.Context, view *View, uri lsp.DocumentURI, changes []lsp.TextDocumentContentChangeEvent) ([]byte, error) {
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("no content changes for %s", uri)
+	}
+	// a change without a range replaces the whole document, so there is no
+	// need to read the current content
+	if len(changes) == 1 && changes[0].Range == nil {
+		return []byte(changes[0].Text), nil
+	}
+
+	snapshot, release, err := view.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	fh, err := snapshot.ReadFile(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	content, err := fh.Content()
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", uri, err)
+	}
+	return applyContentChanges(content, changes)
+}
+
+// applyContentChanges applies changes to content in order. Each change is
+// relative to the content resulting from the previous changes.
+func applyContentChanges(content []byte, changes []lsp.TextDocumentContentChangeEvent) ([]byte, error) {
+	for _, change := range changes {
+		if change.Range == nil {
+			content = []byte(change.Text)
+			continue
+		}
+		start, err := positionOffset(content, change.Range.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := positionOffset(content, change.Range.End)
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, fmt.Errorf("invalid range %v", *change.Range)
+		}
+		var buf bytes.Buffer
+		buf.Grow(start + len(change.Text) + len(content) - end)
+		buf.Write(content[:start])
+		buf.WriteString(change.Text)
+		buf.Write(content[end:])
+		content = buf.Bytes()
+	}
+	return content, nil
+}
+
+// positionOffset returns the byte offset of pos in content. The character of
+// a position counts UTF-16 code units, as required by the protocol. A
+// character past the end of the line refers to the end of the line.
+func positionOffset(content []byte, pos lsp.Position) (int, error) {
+	offset := 0
+	for range pos.Line {
+		i := bytes.IndexByte(content[offset:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("line %d is out of range", pos.Line)
+		}
+		offset += i + 1
+	}
+	for col := int32(0); col < pos.Character && offset < len(content); {
+		r, size := utf8.DecodeRune(content[offset:])
+		if r == '\n' {
+			break
+		}
+		col += int32(utf16.RuneLen(r))
+		offset += size
+	}
+	return offset, nil
+}
+
+// offsetPosition returns the position of the byte offset in content, the
+// inverse of positionOffset.
+func offsetPosition(content []byte, offset int) lsp.Position {
+	offset = min(offset, len(content))
+	line := bytes.Count(content[:offset], []byte("\n"))
+	lineStart := bytes.LastIndexByte(content[:offset], '\n') + 1
+	var col int32
+	for _, r := range string(content[lineStart:offset]) {
+		col += int32(utf16.RuneLen(r))
+	}
+	return lsp.Position{Line: int32(line), Character: col}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/corymhall/pulumilsp/file"
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyContentChanges(t *testing.T) {
+	change := func(startLine, startChar, endLine, endChar int32, text string) lsp.TextDocumentContentChangeEvent {
+		return lsp.TextDocumentContentChangeEvent{
+			Range: &lsp.Range{
+				Start: lsp.Position{Line: startLine, Character: startChar},
+				End:   lsp.Position{Line: endLine, Character: endChar},
+			},
+			Text: text,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		changes []lsp.TextDocumentContentChangeEvent
+		want    string
+	}{
+		{
+			name:    "insert",
+			content: "new Bucket('logs');\n",
+			changes: []lsp.TextDocumentContentChangeEvent{change(0, 17, 0, 17, ", {}")},
+			want:    "new Bucket('logs', {});\n",
+		},
+		{
+			name:    "replace across lines",
+			content: "a\nbc\ndef\n",
+			changes: []lsp.TextDocumentContentChangeEvent{change(0, 1, 2, 1, "X")},
+			want:    "aXef\n",
+		},
+		{
+			name:    "sequential changes",
+			content: "one\ntwo\n",
+			changes: []lsp.TextDocumentContentChangeEvent{
+				change(0, 0, 0, 3, "1"),
+				change(1, 0, 1, 3, "2"),
+			},
+			want: "1\n2\n",
+		},
+		{
+			name:    "full replacement",
+			content: "old",
+			changes: []lsp.TextDocumentContentChangeEvent{{Text: "new"}, change(0, 3, 0, 3, "er")},
+			want:    "newer",
+		},
+		{
+			// characters count UTF-16 code units, the emoji is two of them
+			name:    "utf16 columns",
+			content: "'🪣é' + x\n",
+			changes: []lsp.TextDocumentContentChangeEvent{change(0, 8, 0, 9, "y")},
+			want:    "'🪣é' + y\n",
+		},
+		{
+			name:    "append at end of file",
+			content: "a\n",
+			changes: []lsp.TextDocumentContentChangeEvent{change(1, 0, 1, 0, "b")},
+			want:    "a\nb",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyContentChanges([]byte(tt.content), tt.changes)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, string(got))
+		})
+	}
+
+	_, err := applyContentChanges([]byte("a\n"), []lsp.TextDocumentContentChangeEvent{change(3, 0, 3, 0, "b")})
+	require.Error(t, err)
+}
+
+func TestOffsetPosition(t *testing.T) {
+	content := []byte("{\n  \"name\": \"😀\",\n}")
+	for offset, want := range map[int]string{0: "0:0", 2: "1:0", 12: "1:10", 17: "1:13", 100: "2:1"} {
+		pos := offsetPosition(content, offset)
+		require.Equal(t, want, fmt.Sprintf("%d:%d", pos.Line, pos.Character), "offset %d", offset)
+		if offset <= len(content) {
+			back, err := positionOffset(content, pos)
+			require.NoError(t, err)
+			require.Equal(t, offset, back)
+		}
+	}
+}
+
+func TestModifiedFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "index.ts")
+	require.NoError(t, os.WriteFile(path, []byte("saved"), 0o644))
+	uri := lsp.URIFromPath(path)
+	snapshot := &Snapshot{files: make(fileMap)}
+
+	open := modifiedFile(ctx, snapshot, file.Modification{URI: uri, Action: file.Open, Version: 1, Text: []byte("saved")})
+	require.Equal(t, int32(1), open.Version())
+
+	snapshot.files[uri] = modifiedFile(ctx, snapshot, file.Modification{URI: uri, Action: file.Change, Version: 4, Text: []byte("edited")})
+	saved := modifiedFile(ctx, snapshot, file.Modification{URI: uri, Action: file.Save})
+	require.Equal(t, int32(4), saved.Version(), "saving keeps the document version")
+	content, err := saved.Content()
+	require.NoError(t, err)
+	require.Equal(t, "edited", string(content))
+
+	closed := modifiedFile(ctx, snapshot, file.Modification{URI: uri, Action: file.Close, Version: -1})
+	require.IsType(t, &diskFile{}, closed)
+	content, err = closed.Content()
+	require.NoError(t, err)
+	require.Equal(t, "saved", string(content))
+}
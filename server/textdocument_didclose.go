@@ -0,0 +1,23 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/corymhall/pulumilsp/debug"
+	"github.com/corymhall/pulumilsp/file"
+	"github.com/corymhall/pulumilsp/lsp"
+)
+
+func (s *server) DidClose(ctx context.Context, params *lsp.DidCloseTextDocumentParams) error {
+	ctx, done := debug.Start(ctx, "DidClose", slog.String("uri", string(params.TextDocument.URI)))
+	defer done()
+	uri := params.TextDocument.URI
+	// the cached parse tree is of the overlay, which is about to be dropped
+	s.parsers.Forget(string(uri))
+	return s.didModifyFiles(ctx, []file.Modification{{
+		URI:     uri,
+		Action:  file.Close,
+		Version: -1,
+	}}, FromDidClose)
+}
@@ -3,6 +3,11 @@ package server
 import (
 	"context"
 	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/corymhall/pulumilsp/file"
@@ -12,6 +17,7 @@ import (
 type StateChange struct {
 	Modifications     []file.Modification
 	Files             fileMap
+	Settings          *Settings               // the new settings, if they changed
 	PolicyDiagnostics map[lsp.DocumentURI]any // TODO:
 }
 
@@ -36,6 +42,26 @@ type View struct {
 	// accordingly.
 	initializationSema chan struct{}
 
+	// diagnosticsSema limits the diagnostics runs of the view, which can be
+	// expensive, to one at a time. Views are diagnosed independently.
+	diagnosticsSema chan unit
+
+	// configured are the settings last read from the client for the view,
+	// guarded by snapshotMu. The settings of a snapshot differ if another
+	// stack was selected.
+	configured *Settings
+
+	// previewedResources is the number of resources registered by the last
+	// completed preview of the view, guarded by snapshotMu. It estimates the
+	// progress of the next preview.
+	previewedResources int
+
+	// shadow is the copy of the project used to preview unsaved changes.
+	shadow *shadowWorkspace
+
+	// policies watches the local policy packs of the project.
+	policies *policyWatcher
+
 	initialWorkspaceLoad       chan struct{}
 	cancelInitialWorkspaceLoad func() // cancel the initial workspace load
 }
@@ -53,9 +79,42 @@ func (v *View) shutdown() {
 	v.snapshotMu.Unlock()
 }
 
+// Settings returns the settings of the current snapshot of the view, or the
+// settings it was created with after it was shut down.
+func (v *View) Settings() *Settings {
+	v.snapshotMu.Lock()
+	defer v.snapshotMu.Unlock()
+	if v.snapshot == nil {
+		return v.viewDefinition.settings
+	}
+	return v.snapshot.settings
+}
+
+// lastPreviewSize returns the number of resources registered by the last
+// completed preview of the view, or 0 if no preview has completed.
+func (v *View) lastPreviewSize() int {
+	v.snapshotMu.Lock()
+	defer v.snapshotMu.Unlock()
+	return v.previewedResources
+}
+
+func (v *View) setLastPreviewSize(resources int) {
+	v.snapshotMu.Lock()
+	defer v.snapshotMu.Unlock()
+	v.previewedResources = resources
+}
+
+// isShutdown reports whether shutdown has been called.
+func (v *View) isShutdown() bool {
+	v.snapshotMu.Lock()
+	defer v.snapshotMu.Unlock()
+	return v.snapshot == nil
+}
+
 type viewDefinition struct {
 	root       lsp.DocumentURI // root directory; where to run the Pulumi command
-	pulumiyaml lsp.DocumentURI // the nearest Pulumi.yaml file
+	pulumiyaml lsp.DocumentURI // the nearest Pulumi.yaml or Pulumi.yml file
+	settings   *Settings       // the settings the view was created with
 }
 
 // Snapshot returns the current snapshot for the view, and a
@@ -72,3 +131,69 @@ func (v *View) Snapshot() (*Snapshot, func(), error) {
 	}
 	return v.snapshot, v.snapshot.Acquire(), nil
 }
+
+// nearestView returns the view whose project directory is the nearest
+// ancestor of uri, or nil if uri is not in any of the views' projects.
+func nearestView(views []*View, uri lsp.DocumentURI) *View {
+	if !strings.HasPrefix(string(uri), "file://") {
+		return nil
+	}
+	path := uri.Path()
+	var nearest *View
+	for _, v := range views {
+		dir := v.root.Path()
+		if path != dir && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			continue
+		}
+		if nearest == nil || len(dir) > len(nearest.root.Path()) {
+			nearest = v
+		}
+	}
+	return nearest
+}
+
+// findProjects returns the directories of the Pulumi projects in folder, i.e.
+// those that contain a Pulumi.yaml or Pulumi.yml. If there are none, the folder may be
+// inside a project, in which case that project's directory is returned.
+func findProjects(folder string) ([]string, error) {
+	var roots []string
+	err := filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != folder && (skippedDirs[d.Name()] || linkedDirs[d.Name()]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if slices.Contains(file.ProjectFiles, d.Name()) {
+			roots = append(roots, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil || len(roots) > 0 {
+		return roots, err
+	}
+
+	for dir := filepath.Dir(folder); ; dir = filepath.Dir(dir) {
+		if _, ok := projectFile(dir); ok {
+			return []string{dir}, nil
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return nil, nil
+		}
+	}
+}
+
+// projectFile returns the path of the file that defines the Pulumi project
+// in dir, and whether there is one.
+func projectFile(dir string) (string, bool) {
+	for _, name := range file.ProjectFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return filepath.Join(dir, file.ProjectFiles[0]), false
+}
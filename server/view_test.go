@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNearestView(t *testing.T) {
+	view := func(root string) *View {
+		return &View{viewDefinition: &viewDefinition{root: lsp.URIFromPath(root)}}
+	}
+	infra := view("/repo/infra")
+	web := view("/repo/infra/web")
+	webapp := view("/repo/infra/webapp")
+	views := []*View{infra, web, webapp}
+
+	require.Equal(t, web, nearestView(views, "file:///repo/infra/web/index.ts"))
+	require.Equal(t, web, nearestView(views, "file:///repo/infra/web/lib/bucket.ts"))
+	require.Equal(t, webapp, nearestView(views, "file:///repo/infra/webapp/index.ts"))
+	require.Equal(t, infra, nearestView(views, "file:///repo/infra/shared/index.ts"))
+	require.Nil(t, nearestView(views, "file:///repo/app/index.ts"))
+	require.Nil(t, nearestView(views, "untitled:Untitled-1"))
+}
+
+func TestFindProjects(t *testing.T) {
+	root := t.TempDir()
+	for _, rel := range []string{
+		"infra/web/Pulumi.yaml",
+		"infra/db/Pulumi.yaml",
+		"infra/queue/Pulumi.yml",
+		"infra/queue/src/index.ts",
+		"infra/web/node_modules/pkg/Pulumi.yaml",
+		"infra/web/src/index.ts",
+		"app/index.ts",
+	} {
+		path := filepath.Join(root, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, nil, 0o644))
+	}
+
+	projects, err := findProjects(root)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		filepath.Join(root, "infra", "web"),
+		filepath.Join(root, "infra", "db"),
+		filepath.Join(root, "infra", "queue"),
+	}, projects)
+
+	// a folder inside a project belongs to that project
+	projects, err = findProjects(filepath.Join(root, "infra", "web", "src"))
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(root, "infra", "web")}, projects)
+	projects, err = findProjects(filepath.Join(root, "infra", "queue", "src"))
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(root, "infra", "queue")}, projects)
+	path, ok := projectFile(filepath.Join(root, "infra", "queue"))
+	require.True(t, ok)
+	require.Equal(t, filepath.Join(root, "infra", "queue", "Pulumi.yml"), path)
+
+	projects, err = findProjects(filepath.Join(root, "app"))
+	require.NoError(t, err)
+	require.Empty(t, projects)
+}
+
+func TestDidChangeWorkspaceFoldersDuringUpdate(t *testing.T) {
+	s := &server{}
+	// views are being initialized
+	s.updateViewsMu.Lock()
+	done := make(chan error)
+	go func() {
+		done <- s.DidChangeWorkspaceFolders(context.Background(), &lsp.DidChangeWorkspaceFoldersParams{
+			Event: lsp.WorkspaceFoldersChangeEvent{Added: []lsp.WorkspaceFolder{{URI: lsp.URIFromPath(t.TempDir())}}},
+		})
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("DidChangeWorkspaceFolders blocked on the view update in progress")
+	}
+	s.foldersMu.Lock()
+	require.Len(t, s.folders, 1)
+	s.foldersMu.Unlock()
+	s.updateViewsMu.Unlock()
+}
@@ -0,0 +1,14 @@
+package server
+
+import (
+	"context"
+
+	"github.com/corymhall/pulumilsp/debug"
+	"github.com/corymhall/pulumilsp/lsp"
+)
+
+func (s *server) WorkDoneProgressCancel(ctx context.Context, params *lsp.WorkDoneProgressCancelParams) error {
+	ctx, done := debug.Start(ctx, "WorkDoneProgressCancel")
+	defer done()
+	return s.progress.Cancel(params.Token)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"slices"
+
+	"github.com/corymhall/pulumilsp/debug"
+	"github.com/corymhall/pulumilsp/logger"
+	"github.com/corymhall/pulumilsp/lsp"
+)
+
+// settingsSection is the section of the client's configuration that holds
+// the settings of the server.
+const settingsSection = "pulumilsp"
+
+func (s *server) DidChangeConfiguration(ctx context.Context, params *lsp.DidChangeConfigurationParams) error {
+	ctx, done := debug.Start(ctx, "DidChangeConfiguration")
+	defer done()
+	if !s.supportsConfiguration && params.Settings != nil {
+		// the settings can't be pulled, so use the pushed ones as defaults,
+		// over the initialization options
+		var pushed struct {
+			Settings json.RawMessage `json:"pulumilsp"`
+		}
+		raw, err := json.Marshal(params.Settings)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, &pushed); err != nil {
+			return err
+		}
+		if pushed.Settings != nil {
+			s.settingsMu.Lock()
+			settings, err := parseSettings(s.initOptions, pushed.Settings)
+			if err == nil {
+				s.defaults = settings
+			}
+			s.settingsMu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// pulling settings sends requests to the client, do this in a separate
+	// goroutine, otherwise it will block from receiving the responses
+	go func() {
+		s.applyGlobalSettings(ctx, s.settingsFor(ctx, nil))
+		s.updateSettings(ctx)
+	}()
+	return nil
+}
+
+// settingsFor returns the settings for the project at scope, or the settings
+// that are not specific to a project if scope is nil. They are pulled from
+// the client if it supports workspace/configuration, with the defaults for
+// any setting that isn't set. The returned settings are a copy that the
+// caller may modify.
+func (s *server) settingsFor(ctx context.Context, scope *lsp.DocumentURI) *Settings {
+	s.settingsMu.Lock()
+	defaults := s.defaults.clone()
+	s.settingsMu.Unlock()
+	if !s.supportsConfiguration {
+		return defaults
+	}
+
+	section := settingsSection
+	result, err := s.client.Configuration(ctx, &lsp.ParamConfiguration{
+		Items: []lsp.ConfigurationItem{{ScopeURI: scope, Section: &section}},
+	})
+	if err != nil || len(result) != 1 || result[0] == nil {
+		if err != nil {
+			debug.LogError(ctx, "error getting configuration", err)
+		}
+		return defaults
+	}
+	raw, err := json.Marshal(result[0])
+	if err != nil {
+		debug.LogError(ctx, "error marshalling configuration", err)
+		return defaults
+	}
+	settings, err := parseSettings(defaults, raw)
+	if err != nil {
+		debug.LogError(ctx, "invalid configuration", err)
+		return defaults
+	}
+	return settings
+}
+
+// applyGlobalSettings applies the settings that aren't specific to a
+// project.
+func (s *server) applyGlobalSettings(ctx context.Context, settings *Settings) {
+	level := settings.logLevel()
+	if logger.ProgramLevel.Level() != level {
+		debug.Info.Log(ctx, "Setting log level", "level", level)
+		logger.ProgramLevel.Set(level)
+	}
+}
+
+// updateSettings pulls the settings of every view. A view whose settings
+// changed gets a new snapshot, which is diagnosed again if the change
+// affects its diagnostics.
+func (s *server) updateSettings(ctx context.Context) {
+	s.viewMu.Lock()
+	views := slices.Clone(s.views)
+	s.viewMu.Unlock()
+
+	for _, view := range views {
+		settings := s.settingsFor(ctx, &view.root)
+
+		s.viewSettingsMu.Lock()
+		view.snapshotMu.Lock()
+		if view.snapshot == nil || reflect.DeepEqual(view.configured, settings) {
+			view.snapshotMu.Unlock()
+			s.viewSettingsMu.Unlock()
+			continue
+		}
+		prev := view.snapshot.settings
+		if settings.Stack == view.configured.Stack {
+			// keep the stack selected with the selectStack command
+			settings.Stack = prev.Stack
+		}
+		view.configured = settings
+		view.snapshotMu.Unlock()
+
+		snapshot, release := s.invalidateViewLocked(ctx, view, StateChange{Settings: settings})
+		s.viewSettingsMu.Unlock()
+		release()
+		s.watchPolicyPacks(ctx, view, settings)
+		if !settings.affectsDiagnostics(prev) {
+			continue
+		}
+		ctx, _ := debug.With(ctx, "snapshotSequenceID", snapshot.sequenceID)
+		modCtx, modID := s.updateViewToDiagnose(ctx, view)
+		go func() {
+			s.diagnoseChangedView(modCtx, view, modID, nil, FromDidChangeConfiguration)
+		}()
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushedSettings(t *testing.T) {
+	ctx := context.Background()
+	initialize := func(options string) *server {
+		s := &server{
+			progress:    NewTracker(nil),
+			initOptions: defaultSettings(),
+			defaults:    defaultSettings(),
+		}
+		raw := json.RawMessage(options)
+		_, err := s.Initialize(ctx, &lsp.InitializeRequestParams{InitializationOptions: &raw})
+		require.NoError(t, err)
+		return s
+	}
+	defaults := func(s *server) *Settings {
+		s.settingsMu.Lock()
+		defer s.settingsMu.Unlock()
+		return s.defaults
+	}
+
+	// invalid options leave the defaults as they are
+	s := initialize(`{"stack":"dev","aiProvider":"gemini"}`)
+	require.Equal(t, defaultSettings(), defaults(s))
+
+	// pushed settings are applied over the initialization options
+	s = initialize(`{"stack":"dev"}`)
+	require.NoError(t, s.DidChangeConfiguration(ctx, &lsp.DidChangeConfigurationParams{
+		Settings: map[string]any{"pulumilsp": map[string]any{"trigger": "onChange"}},
+	}))
+	require.Equal(t, "dev", defaults(s).Stack)
+	require.Equal(t, TriggerOnChange, defaults(s).Trigger)
+	require.NoError(t, s.DidChangeConfiguration(ctx, &lsp.DidChangeConfigurationParams{
+		Settings: map[string]any{"pulumilsp": map[string]any{"previewDelay": "2s"}},
+	}))
+	require.Equal(t, "dev", defaults(s).Stack)
+	require.Equal(t, TriggerOnSave, defaults(s).Trigger)
+	require.Equal(t, "2s", defaults(s).PreviewDelay)
+
+	// the settings of a view are a copy of the defaults
+	settings := s.settingsFor(ctx, nil)
+	settings.Stack = "prod"
+	require.Equal(t, "dev", defaults(s).Stack)
+}
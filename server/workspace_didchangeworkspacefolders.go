@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"slices"
+
+	"github.com/corymhall/pulumilsp/debug"
+	"github.com/corymhall/pulumilsp/lsp"
+)
+
+func (s *server) DidChangeWorkspaceFolders(ctx context.Context, params *lsp.DidChangeWorkspaceFoldersParams) error {
+	ctx, done := debug.Start(ctx, "DidChangeWorkspaceFolders")
+	defer done()
+	s.foldersMu.Lock()
+	for _, folder := range params.Event.Removed {
+		s.folders = slices.DeleteFunc(s.folders, func(uri lsp.DocumentURI) bool {
+			return uri == folder.URI
+		})
+	}
+	for _, folder := range params.Event.Added {
+		if !slices.Contains(s.folders, folder.URI) {
+			s.folders = append(s.folders, folder.URI)
+		}
+	}
+	s.foldersMu.Unlock()
+
+	// creating views waits for them to be initialized, so do this in a
+	// separate goroutine, otherwise it will block from receiving more
+	// requests
+	go func() {
+		s.updateViews(ctx)
+	}()
+	return nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/corymhall/pulumilsp/debug"
+	"github.com/corymhall/pulumilsp/lsp"
+)
+
+// selectStackCommand selects the stack that is previewed for a project.
+const selectStackCommand = "pulumilsp.selectStack"
+
+// SelectStackArgs are the arguments of the selectStack command.
+type SelectStackArgs struct {
+	// URI is the URI of the project directory, or of any file in it.
+	URI lsp.DocumentURI `json:"uri"`
+	// Stack is the name of the stack to preview. If empty, the stack that is
+	// selected in the project is previewed.
+	Stack string `json:"stack"`
+}
+
+func (s *server) ExecuteCommand(ctx context.Context, params *lsp.ExecuteCommandParams) (any, error) {
+	ctx, done := debug.Start(ctx, "ExecuteCommand", slog.String("command", params.Command))
+	defer done()
+	switch params.Command {
+	case selectStackCommand:
+		if len(params.Arguments) != 1 {
+			return nil, fmt.Errorf("%s: expected 1 argument, got %d", params.Command, len(params.Arguments))
+		}
+		var args SelectStackArgs
+		if err := json.Unmarshal(params.Arguments[0], &args); err != nil {
+			return nil, fmt.Errorf("%s: error unmarshalling arguments: %w", params.Command, err)
+		}
+		return nil, s.selectStack(ctx, args)
+	default:
+		return nil, fmt.Errorf("unknown command %q", params.Command)
+	}
+}
+
+// selectStack switches the view of the project at args.URI to another stack
+// and diagnoses it. The stack stays selected until the pulumilsp.stack
+// setting of the project changes.
+func (s *server) selectStack(ctx context.Context, args SelectStackArgs) error {
+	view := s.viewOf(args.URI)
+	if view == nil {
+		return fmt.Errorf("no Pulumi project contains %s", args.URI)
+	}
+	s.viewSettingsMu.Lock()
+	settings := view.Settings().clone()
+	settings.Stack = args.Stack
+	snapshot, release := s.invalidateViewLocked(ctx, view, StateChange{Settings: settings})
+	s.viewSettingsMu.Unlock()
+	release()
+	ctx, _ = debug.With(ctx, "snapshotSequenceID", snapshot.sequenceID, "stack", args.Stack)
+
+	modCtx, modID := s.updateViewToDiagnose(ctx, view)
+	// don't block on diagnostics
+	go func() {
+		s.diagnoseChangedView(modCtx, view, modID, nil, FromSelectStack)
+	}()
+	return nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/corymhall/pulumilsp/lsp"
+	"github.com/corymhall/pulumilsp/pulumicommand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectStackDuringDidChangeConfiguration(t *testing.T) {
+	for range 20 {
+		root := lsp.URIFromPath(t.TempDir())
+		initialWorkspaceLoad := make(chan struct{})
+		close(initialWorkspaceLoad)
+		view := &View{
+			baseCtx:              context.Background(),
+			viewDefinition:       &viewDefinition{root: root, settings: &Settings{Stack: "dev"}},
+			configured:           &Settings{Stack: "dev"},
+			initialWorkspaceLoad: initialWorkspaceLoad,
+			initializationSema:   make(chan struct{}, 1),
+			// previews wait for a slot until they are cancelled
+			diagnosticsSema: make(chan unit),
+			policies:        newPolicyWatcher(context.Background(), func() {}),
+		}
+		view.snapshot = &Snapshot{
+			view:            view,
+			files:           make(fileMap),
+			initialized:     true,
+			pulumicmdRunner: &pulumicommand.Runner{},
+			settings:        &Settings{Stack: "dev"},
+			refcount:        1,
+			done:            func() {},
+		}
+		client := newRecordingClient()
+		s := &server{
+			client:          client,
+			progress:        NewTracker(client),
+			views:           []*View{view},
+			defaults:        &Settings{Stack: "dev", LogLevel: "debug"},
+			cancelDiagnoses: make(map[*View]func()),
+		}
+
+		// the stack is selected while the configuration changes
+		ctx := context.Background()
+		view.snapshotMu.Lock()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.updateSettings(ctx)
+		}()
+		time.Sleep(5 * time.Millisecond)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, s.selectStack(ctx, SelectStackArgs{URI: root, Stack: "dev"}))
+		}()
+		time.Sleep(5 * time.Millisecond)
+		view.snapshotMu.Unlock()
+		wg.Wait()
+
+		// selecting the stack doesn't undo the configuration change
+		settings := view.Settings()
+		require.Equal(t, "dev", settings.Stack)
+		require.Equal(t, "debug", settings.LogLevel)
+
+		s.modificationMu.Lock()
+		for _, cancel := range s.cancelDiagnoses {
+			cancel()
+		}
+		s.modificationMu.Unlock()
+		view.policies.close()
+	}
+}